@@ -0,0 +1,270 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/s-devoe/greenlight-go/internal/data"
+	"github.com/s-devoe/greenlight-go/internal/validator"
+)
+
+// the endpoints under /v1/admin require the users:admin permission
+
+func (app *application) listUsersHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Search    string
+		Activated *bool
+		Disabled  *bool
+		data.Filters
+	}
+
+	v := validator.New()
+	qs := r.URL.Query()
+
+	input.Search = app.readString(qs, "search", "")
+	input.Activated = app.readOptionalBool(qs, "activated", v)
+	input.Disabled = app.readOptionalBool(qs, "disabled", v)
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = app.readString(qs, "sort", "id")
+	input.Filters.SortSafeList = []string{"id", "name", "email", "created_at", "-id", "-name", "-email", "-created_at"}
+
+	v.Check(len(input.Search) <= 500, "search", "must not be more than 500 bytes long")
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	users, metadata, err := app.store.Users.GetAll(r.Context(), input.Search, input.Activated, input.Disabled, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"users": users, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showUserHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.readUserParam(w, r)
+	if !ok {
+		return
+	}
+
+	permissions, err := app.store.Permissions.GetAllPermissionsForUser(r.Context(), user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if permissions == nil {
+		permissions = data.Permissions{}
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"user": user, "permissions": permissions}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// adminUpdateUserHandler deactivates or reactivates an account with disabled, and force-verifies its
+// email address with activated
+func (app *application) adminUpdateUserHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.readUserParam(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Activated *bool `json:"activated"`
+		Disabled  *bool `json:"disabled"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	v.Check(input.Activated != nil || input.Disabled != nil, "body", "activated or disabled must be provided")
+	v.Check(input.Activated == nil || *input.Activated, "activated", "can only be set to true, verifying the email address")
+	v.Check(input.Disabled == nil || !*input.Disabled || user.ID != app.contextGetUser(r).ID, "disabled", "you can't disable your own account")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	verified := input.Activated != nil && !user.Activated
+	disabled := input.Disabled != nil && *input.Disabled && !user.Disabled
+
+	if input.Activated != nil {
+		user.Activated = *input.Activated
+	}
+	if input.Disabled != nil {
+		user.Disabled = *input.Disabled
+	}
+
+	ctx := r.Context()
+
+	// a retry starts over from the user as it was before the update, so the version check still applies
+	updated := *user
+
+	err = app.store.WithTx(ctx, func(tx data.Store) error {
+		*user = updated
+
+		err := tx.Users.UpdateUser(ctx, user)
+		if err != nil {
+			return err
+		}
+
+		if verified {
+			err = tx.Tokens.DeleteAllForUser(ctx, data.ScopeActivation, user.ID)
+			if err != nil {
+				return err
+			}
+		}
+
+		// tokens would be refused anyway, but there's no reason to keep the sessions of a disabled account around
+		if disabled {
+			return app.revokeSessions(ctx, tx, user.ID)
+		}
+		return nil
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrUpdateConflict):
+			app.updateConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if disabled {
+		app.forgetTokenState(user.ID)
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"user": user}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// forcePasswordResetHandler replaces the password of a user with a random one, logs them out and emails
+// them a password reset token, e.g. when their password is believed to be compromised
+func (app *application) forcePasswordResetHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.readUserParam(w, r)
+	if !ok {
+		return
+	}
+
+	ctx := r.Context()
+
+	// a retry starts over from the user as it was loaded, so the version check still applies
+	loaded := *user
+
+	var token *data.Token
+	err := app.store.WithTx(ctx, func(tx data.Store) error {
+		*user = loaded
+
+		err := user.Password.SetRandom()
+		if err != nil {
+			return err
+		}
+
+		err = tx.Users.UpdateUser(ctx, user)
+		if err != nil {
+			return err
+		}
+
+		err = app.revokeSessions(ctx, tx, user.ID)
+		if err != nil {
+			return err
+		}
+
+		token, err = tx.Tokens.New(ctx, user.ID, data.PasswordResetTokenTTL, data.ScopePasswordReset)
+		return err
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrUpdateConflict):
+			app.updateConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.forgetTokenState(user.ID)
+
+	app.background(r.Context(), "password reset email", func(ctx context.Context) {
+		data := map[string]interface{}{
+			"passwordResetToken": token.Plaintext,
+		}
+
+		err := app.mailer.SendMail(ctx, user.Email, "password_reset.tmpl", data)
+		if err != nil {
+			app.logContextError(ctx, err, nil)
+		}
+	})
+
+	err = app.writeJSON(w, http.StatusAccepted, envelope{"message": "the password was reset and the user was emailed instructions to set a new one"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteUserHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	if id == app.contextGetUser(r).ID {
+		app.badRequestResponse(w, r, errors.New("you can't delete your own account"))
+		return
+	}
+
+	err = app.store.Users.Delete(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "user successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// readUserParam fetches the user from the id parameter, responding with a 404 when there's no such user
+func (app *application) readUserParam(w http.ResponseWriter, r *http.Request) (*data.User, bool) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	user, err := app.store.Users.Get(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	return user, true
+}
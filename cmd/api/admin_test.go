@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/s-devoe/greenlight-go/internal/data"
+)
+
+func TestForcePasswordResetRevokesEveryToken(t *testing.T) {
+	app := newTestApplication(t)
+	user := newTestUser(t, app, "alice@example.com")
+	ctx := context.Background()
+
+	personal, err := app.store.Tokens.NewPersonal(ctx, user.ID, "ci", time.Now().Add(time.Hour), data.Permissions{"movies:read"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	authentication, err := app.store.Tokens.New(ctx, user.ID, time.Hour, data.ScopeAuthentication)
+	if err != nil {
+		t.Fatal(err)
+	}
+	magicLink, err := app.store.Tokens.New(ctx, user.ID, time.Hour, data.ScopeMagicLink)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, token := range []string{personal.Plaintext, authentication.Plaintext} {
+		if status := authenticateStatus(t, app, token); status != http.StatusOK {
+			t.Fatalf("token refused before the reset, status %d", status)
+		}
+	}
+
+	r := httptest.NewRequest(http.MethodPost, "/v1/admin/users/"+strconv.FormatInt(user.ID, 10)+"/password-reset", nil)
+	r = r.WithContext(context.WithValue(r.Context(), httprouter.ParamsKey, httprouter.Params{
+		{Key: "id", Value: strconv.FormatInt(user.ID, 10)},
+	}))
+
+	rr := httptest.NewRecorder()
+	app.forcePasswordResetHandler(rr, r)
+
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("force reset status %d: %s", rr.Code, rr.Body)
+	}
+
+	if status := authenticateStatus(t, app, personal.Plaintext); status != http.StatusUnauthorized {
+		t.Errorf("personal access token after the reset: status %d, want %d", status, http.StatusUnauthorized)
+	}
+	if status := authenticateStatus(t, app, authentication.Plaintext); status != http.StatusUnauthorized {
+		t.Errorf("authentication token after the reset: status %d, want %d", status, http.StatusUnauthorized)
+	}
+
+	_, err = app.store.Users.GetForToken(ctx, data.ScopeMagicLink, magicLink.Plaintext)
+	if !errors.Is(err, data.ErrRecordNotFound) {
+		t.Errorf("magic link after the reset: error %v, want %v", err, data.ErrRecordNotFound)
+	}
+}
+
+func TestDisabledUserPersonalAccessToken(t *testing.T) {
+	app := newTestApplication(t)
+	user := newTestUser(t, app, "bob@example.com")
+	ctx := context.Background()
+
+	personal, err := app.store.Tokens.NewPersonal(ctx, user.ID, "ci", time.Now().Add(time.Hour), data.Permissions{"movies:read"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// disabled straight in the database, without going through the handler revoking the tokens
+	user.Disabled = true
+	err = app.store.Users.UpdateUser(ctx, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/v1/movies", nil)
+	r.Header.Set("Authorization", "Bearer "+personal.Plaintext)
+
+	rr := httptest.NewRecorder()
+	app.authenticate(http.NotFoundHandler()).ServeHTTP(rr, r)
+
+	if rr.Code != http.StatusForbidden || !strings.Contains(rr.Body.String(), "disabled") {
+		t.Errorf("personal access token of a disabled user: status %d %s, want %d", rr.Code, rr.Body, http.StatusForbidden)
+	}
+}
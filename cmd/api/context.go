@@ -9,7 +9,12 @@ import (
 
 type contextKey string
 
-const userContextKey = contextKey("user")
+const (
+	userContextKey        = contextKey("user")
+	permissionsContextKey = contextKey("permissions")
+	tokenScopeContextKey  = contextKey("tokenScope")
+	requestInfoContextKey = contextKey("requestInfo")
+)
 
 func (app *application) contextSetUser(r *http.Request, user *data.User) *http.Request {
 	ctx := context.WithValue(r.Context(), userContextKey, user)
@@ -23,3 +28,59 @@ func (a *application) contextGetUser(r *http.Request) *data.User {
 	}
 	return user
 }
+
+// contextSetPermissions stores the permissions a request is restricted to by the token it was authenticated with
+func (app *application) contextSetPermissions(r *http.Request, permissions data.Permissions) *http.Request {
+	ctx := context.WithValue(r.Context(), permissionsContextKey, permissions)
+	return r.WithContext(ctx)
+}
+
+// contextGetPermissions returns the permissions set by contextSetPermissions, ok is false when
+// the request wasn't authenticated with a restricted token
+func (app *application) contextGetPermissions(r *http.Request) (permissions data.Permissions, ok bool) {
+	permissions, ok = r.Context().Value(permissionsContextKey).(data.Permissions)
+	return permissions, ok
+}
+
+// contextSetTokenScope stores the scope of the token a request was authenticated with
+func (app *application) contextSetTokenScope(r *http.Request, scope string) *http.Request {
+	ctx := context.WithValue(r.Context(), tokenScopeContextKey, scope)
+	return r.WithContext(ctx)
+}
+
+func (app *application) contextGetTokenScope(r *http.Request) string {
+	scope, _ := r.Context().Value(tokenScopeContextKey).(string)
+	return scope
+}
+
+// requestInfo is shared by all the middleware handling a request, so what is learned further down the
+// chain (the matched route, the authenticated user) is known to the outer middleware logging and measuring it
+type requestInfo struct {
+	ID     string
+	Route  string
+	UserID int64
+}
+
+func (app *application) contextSetRequestInfo(r *http.Request, info *requestInfo) *http.Request {
+	ctx := context.WithValue(r.Context(), requestInfoContextKey, info)
+	return r.WithContext(ctx)
+}
+
+// contextGetRequestInfo returns the info set by the logRequest middleware, or a throwaway one
+// for requests that didn't go through it
+func (app *application) contextGetRequestInfo(r *http.Request) *requestInfo {
+	info, ok := r.Context().Value(requestInfoContextKey).(*requestInfo)
+	if !ok {
+		return &requestInfo{}
+	}
+	return info
+}
+
+// requestID returns the id of the request ctx belongs to, background tasks keep the id of the request starting them
+func requestID(ctx context.Context) string {
+	info, _ := ctx.Value(requestInfoContextKey).(*requestInfo)
+	if info == nil {
+		return ""
+	}
+	return info.ID
+}
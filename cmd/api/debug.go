@@ -0,0 +1,76 @@
+package main
+
+import (
+	"errors"
+	"expvar"
+	"net/http"
+	"net/http/pprof"
+
+	"github.com/s-devoe/greenlight-go/internal/jsonlog"
+	"github.com/s-devoe/greenlight-go/internal/metrics"
+)
+
+// debugRoutes are served by the admin listener, which only binds to localhost. nothing here is
+// authenticated so none of it may be mounted on the public router
+func (app *application) debugRoutes() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.Handle("GET /debug/vars", expvar.Handler())
+	mux.Handle("GET /metrics", metrics.Handler())
+
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+
+	mux.HandleFunc("GET /debug/log-level", app.showLogLevelHandler)
+	mux.HandleFunc("PUT /debug/log-level", app.updateLogLevelHandler)
+	mux.HandleFunc("GET /debug/config", app.showConfigHandler)
+
+	return app.recoverPanic(mux)
+}
+
+func (app *application) showLogLevelHandler(w http.ResponseWriter, r *http.Request) {
+	err := app.writeJSON(w, http.StatusOK, envelope{"level": app.logger.Level().String()}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// updateLogLevelHandler changes the log level until the next restart, e.g. to turn on
+// DEBUG while looking into a problem
+func (app *application) updateLogLevelHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Level string `json:"level"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	level, err := jsonlog.ParseLevel(input.Level)
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("level must be one of DEBUG, INFO, WARN, ERROR, FATAL or OFF"))
+		return
+	}
+
+	previous := app.logger.Level()
+	app.logger.SetLevel(level)
+
+	app.logger.Warn("log level changed", jsonlog.String("from", previous.String()), jsonlog.String("to", level.String()))
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"level": level.String()}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showConfigHandler(w http.ResponseWriter, r *http.Request) {
+	err := app.writeJSON(w, http.StatusOK, envelope{"config": app.config.Redacted()}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -1,8 +1,15 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"math"
 	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/s-devoe/greenlight-go/internal/data"
+	"github.com/s-devoe/greenlight-go/internal/metrics"
 )
 
 func (app *application) notPermittedResponse(w http.ResponseWriter, r *http.Request) {
@@ -12,11 +19,24 @@ func (app *application) notPermittedResponse(w http.ResponseWriter, r *http.Requ
 
 func (app *application) logError(r *http.Request, err error) {
 	app.logger.PrintError(err, map[string]string{
+		"request_id":     requestID(r.Context()),
 		"request_method": r.Method,
 		"request_url":    r.URL.String(),
 	})
 }
 
+// logContextError logs an error from code without the request at hand, like background tasks,
+// along with the id of the request it's done for
+func (app *application) logContextError(ctx context.Context, err error, properties map[string]string) {
+	if id := requestID(ctx); id != "" {
+		if properties == nil {
+			properties = map[string]string{}
+		}
+		properties["request_id"] = id
+	}
+	app.logger.PrintError(err, properties)
+}
+
 func (app *application) errorResponse(w http.ResponseWriter, r *http.Request, status int, message interface{}) {
 	env := envelope{"error": message}
 
@@ -54,22 +74,97 @@ func (app *application) updateConflictResponse(w http.ResponseWriter, r *http.Re
 	app.errorResponse(w, r, http.StatusConflict, message)
 }
 
+func (app *application) duplicateExternalIDResponse(w http.ResponseWriter, r *http.Request) {
+	message := "a movie with this imdb_id or tmdb_id already exists"
+	app.errorResponse(w, r, http.StatusConflict, message)
+}
+
+// duplicateMovieResponse is sent when a movie with the same title and year exists, along with the
+// similar movies that were found when there's a list of them
+func (app *application) duplicateMovieResponse(w http.ResponseWriter, r *http.Request, duplicates []*data.MovieSuggestion) {
+	env := envelope{"error": "a movie with the same title and year already exists"}
+	if len(duplicates) > 0 {
+		env["duplicates"] = duplicates
+	}
+
+	err := app.writeJSON(w, http.StatusConflict, env, nil)
+	if err != nil {
+		app.logError(r, err)
+		w.WriteHeader(500)
+	}
+}
+
 func (app *application) invalidCredentialsResponse(w http.ResponseWriter, r *http.Request) {
 	message := "invalid authentication credentials"
 	app.errorResponse(w, r, http.StatusUnauthorized, message)
 }
 
+// loginBlockedResponse is sent while an account or IP has to wait after failed logins,
+// Retry-After tells the client when it can try again
+func (app *application) loginBlockedResponse(w http.ResponseWriter, r *http.Request, wait time.Duration) {
+	metrics.RateLimited.WithLabelValues("login").Inc()
+
+	seconds := int(math.Ceil(wait.Seconds()))
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+
+	message := fmt.Sprintf("too many failed login attempts, try again in %d seconds", seconds)
+	if wait > time.Minute {
+		message = fmt.Sprintf("too many failed login attempts, the account is locked for %s", wait.Round(time.Minute))
+	}
+	app.errorResponse(w, r, http.StatusTooManyRequests, message)
+}
+
 func (app *application) invalidAuthenticationTokenResponse(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("WWW-Authenticate", "Bearer")
 	message := "invalid or missing authentication token"
 	app.errorResponse(w, r, http.StatusUnauthorized, message)
 }
 
+func (app *application) invalidRefreshTokenResponse(w http.ResponseWriter, r *http.Request) {
+	message := "invalid or expired refresh token"
+	app.errorResponse(w, r, http.StatusUnauthorized, message)
+}
+
+func (app *application) refreshTokenReusedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "refresh token has already been used, every token issued with it has been revoked, please log in again"
+	app.errorResponse(w, r, http.StatusUnauthorized, message)
+}
+
+func (app *application) personalAccessTokenNotAllowedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "personal access tokens can't be used for this action, authenticate with your password instead"
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
+
+func (app *application) unverifiedIdentityResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the identity provider hasn't verified your email address"
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
+
+func (app *application) identityNotLinkedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "an account with this email address already exists, log in to it and link this identity from your account first"
+	app.errorResponse(w, r, http.StatusConflict, message)
+}
+
+func (app *application) identityLinkedElsewhereResponse(w http.ResponseWriter, r *http.Request) {
+	message := "this identity is already linked to another account"
+	app.errorResponse(w, r, http.StatusConflict, message)
+}
+
+func (app *application) totpAlreadyEnabledResponse(w http.ResponseWriter, r *http.Request) {
+	message := "two-factor authentication is already enabled"
+	app.errorResponse(w, r, http.StatusConflict, message)
+}
+
 func (app *application) inActiveAccountResponse(w http.ResponseWriter, r *http.Request) {
 	message := "your user account must be activated to access this resource"
 	app.errorResponse(w, r, http.StatusForbidden, message)
 }
 
+func (app *application) accountDisabledResponse(w http.ResponseWriter, r *http.Request) {
+	message := "your user account has been disabled, please contact an administrator"
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
+
 func (app *application) authenticationRequiredResponse(w http.ResponseWriter, r *http.Request) {
 	message := "you must be authenticated to access this resource"
 	app.errorResponse(w, r, http.StatusUnauthorized, message)
@@ -1,7 +1,14 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"net/http"
+	"sync"
+	"time"
+
+	"github.com/s-devoe/greenlight-go/internal/jsonlog"
+	"github.com/s-devoe/greenlight-go/migrations"
 )
 
 func (app *application) healthcheckHandler(w http.ResponseWriter, r *http.Request) {
@@ -21,3 +28,192 @@ func (app *application) healthcheckHandler(w http.ResponseWriter, r *http.Reques
 	}
 
 }
+
+// livenessHandler only says the process is up and serving, restarting it is the fix when it isn't.
+// it stays successful during shutdown so the process isn't killed while it drains
+func (app *application) livenessHandler(w http.ResponseWriter, r *http.Request) {
+	err := app.writeJSON(w, http.StatusOK, envelope{"status": "alive"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// readinessCheck is one dependency checked by the readiness endpoint. a failing check that isn't
+// critical is reported but leaves the instance ready, as it can still serve most requests
+type readinessCheck struct {
+	name     string
+	timeout  time.Duration
+	critical bool
+	check    func(ctx context.Context) error
+}
+
+// readinessResult is what's reported for a check. the endpoint is public, so why a check fails is
+// only logged, errors can carry host names and other details about the infrastructure
+type readinessResult struct {
+	Status    string  `json:"status"`
+	LatencyMS float64 `json:"latency_ms"`
+}
+
+func (app *application) readinessChecks() []readinessCheck {
+	return []readinessCheck{
+		{name: "database", timeout: 2 * time.Second, critical: true, check: app.store.Ping},
+		{name: "migrations", timeout: 2 * time.Second, critical: true, check: app.checkMigrations},
+		{name: "mailer", timeout: 3 * time.Second, critical: false, check: app.mailerCheck.Check},
+	}
+}
+
+// cachedCheck remembers the outcome of a check, for dependencies that are too slow or too expensive
+// to check on every probe. a failure is kept for less time than a success so a recovered dependency
+// shows up quickly. only one check runs at a time, concurrent probes wait for it without holding the lock
+type cachedCheck struct {
+	mu         sync.Mutex
+	successTTL time.Duration
+	failureTTL time.Duration
+	check      func(ctx context.Context) error
+	err        error
+	checkedAt  time.Time
+	inflight   chan struct{}
+}
+
+func newCachedCheck(successTTL, failureTTL time.Duration, check func(ctx context.Context) error) *cachedCheck {
+	return &cachedCheck{successTTL: successTTL, failureTTL: failureTTL, check: check}
+}
+
+func (c *cachedCheck) Check(ctx context.Context) error {
+	c.mu.Lock()
+	if c.fresh() {
+		err := c.err
+		c.mu.Unlock()
+		return err
+	}
+
+	if done := c.inflight; done != nil {
+		c.mu.Unlock()
+
+		select {
+		case <-done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return c.err
+	}
+
+	done := make(chan struct{})
+	c.inflight = done
+	c.mu.Unlock()
+
+	err := c.check(ctx)
+
+	c.mu.Lock()
+	c.err = err
+	c.checkedAt = time.Now()
+	c.inflight = nil
+	c.mu.Unlock()
+	close(done)
+
+	return err
+}
+
+// fresh reports whether the last outcome is still good to use, c.mu must be held
+func (c *cachedCheck) fresh() bool {
+	if c.checkedAt.IsZero() {
+		return false
+	}
+
+	ttl := c.successTTL
+	if c.err != nil {
+		ttl = c.failureTTL
+	}
+	return time.Since(c.checkedAt) <= ttl
+}
+
+// readinessHandler checks the dependencies concurrently, each under its own timeout, and fails with
+// 503 when a critical one is down or the server is shutting down, so load balancers stop sending traffic
+func (app *application) readinessHandler(w http.ResponseWriter, r *http.Request) {
+	if app.shuttingDown.Load() {
+		err := app.writeJSON(w, http.StatusServiceUnavailable, envelope{"status": "shutting_down"}, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	checks := app.readinessChecks()
+	results := make(map[string]readinessResult, len(checks))
+
+	var (
+		mu sync.Mutex
+		wg sync.WaitGroup
+	)
+	for _, c := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			ctx, cancel := context.WithTimeout(r.Context(), c.timeout)
+			defer cancel()
+
+			start := time.Now()
+			err := c.check(ctx)
+			result := readinessResult{
+				Status:    "ok",
+				LatencyMS: float64(time.Since(start).Microseconds()) / 1000,
+			}
+			if err != nil {
+				result.Status = "failing"
+				app.logger.Warn("readiness check failing",
+					jsonlog.String("check", c.name),
+					jsonlog.String("error", err.Error()),
+				)
+			}
+
+			mu.Lock()
+			results[c.name] = result
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	status, code := "ready", http.StatusOK
+	for _, c := range checks {
+		if results[c.name].Status == "ok" {
+			continue
+		}
+		if c.critical {
+			status, code = "not_ready", http.StatusServiceUnavailable
+			break
+		}
+		status = "degraded"
+	}
+
+	err := app.writeJSON(w, code, envelope{"status": status, "checks": results}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// checkMigrations fails when the database schema is behind the migrations this binary was built
+// with, or when a migration was left dirty. a newer schema is fine, it's what a rolling deploy looks like
+func (app *application) checkMigrations(ctx context.Context) error {
+	expected, err := migrations.Latest()
+	if err != nil {
+		return err
+	}
+
+	current, dirty, err := app.store.MigrationVersion(ctx)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case dirty:
+		return fmt.Errorf("migration %d is dirty", current)
+	case current < expected:
+		return fmt.Errorf("schema version %d is behind %d", current, expected)
+	}
+
+	return nil
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCachedCheckSingleFlight(t *testing.T) {
+	var calls atomic.Int32
+	release := make(chan struct{})
+
+	c := newCachedCheck(time.Minute, time.Minute, func(ctx context.Context) error {
+		calls.Add(1)
+		<-release
+		return nil
+	})
+
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := c.Check(context.Background()); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+
+	// give the probes time to pile up behind the check in flight
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if n := calls.Load(); n != 1 {
+		t.Errorf("check ran %d times, want 1", n)
+	}
+}
+
+func TestCachedCheckWaiterTimeout(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+
+	c := newCachedCheck(time.Minute, time.Minute, func(ctx context.Context) error {
+		<-release
+		return nil
+	})
+
+	go c.Check(context.Background())
+	time.Sleep(10 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	err := c.Check(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("waiting probe got %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestCachedCheckFailureTTL(t *testing.T) {
+	var calls atomic.Int32
+	failing := errors.New("connection refused")
+
+	c := newCachedCheck(time.Hour, 20*time.Millisecond, func(ctx context.Context) error {
+		if calls.Add(1) == 1 {
+			return failing
+		}
+		return nil
+	})
+
+	if err := c.Check(context.Background()); !errors.Is(err, failing) {
+		t.Fatalf("first check got %v, want %v", err, failing)
+	}
+	if err := c.Check(context.Background()); !errors.Is(err, failing) {
+		t.Fatalf("cached failure got %v, want %v", err, failing)
+	}
+
+	time.Sleep(30 * time.Millisecond)
+
+	if err := c.Check(context.Background()); err != nil {
+		t.Fatalf("check after the failure expired got %v", err)
+	}
+
+	time.Sleep(30 * time.Millisecond)
+
+	c.Check(context.Background())
+	if n := calls.Load(); n != 2 {
+		t.Errorf("check ran %d times, want 2, a success is kept for the success ttl", n)
+	}
+}
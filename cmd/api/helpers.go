@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -11,22 +12,31 @@ import (
 	"strings"
 
 	"github.com/julienschmidt/httprouter"
+	"github.com/s-devoe/greenlight-go/internal/tracing"
 	"github.com/s-devoe/greenlight-go/internal/validator"
 )
 
 type envelope map[string]interface{}
 
-func (app *application) background(fn func()) {
+// background runs fn in its own goroutine, waited for on shutdown. ctx is usually the request context,
+// fn gets it without its cancellation, so it keeps the request's values (like the trace) and isn't
+// cut short when the response is sent
+func (app *application) background(ctx context.Context, name string, fn func(ctx context.Context)) {
+	ctx, span := tracing.Tracer().Start(context.WithoutCancel(ctx), "background "+name)
+
 	app.wg.Add(1)
 	go func() {
 		defer app.wg.Done()
+		defer span.End()
 		defer func() {
 			if err := recover(); err != nil {
-				app.logger.PrintError(fmt.Errorf("%s", err), nil)
+				err := fmt.Errorf("%s", err)
+				tracing.RecordError(span, err)
+				app.logContextError(ctx, err, nil)
 			}
 
 		}()
-		fn()
+		fn(ctx)
 	}()
 }
 
@@ -63,6 +73,43 @@ func (app *application) readInt(qs url.Values, key string, defaultValue int, v *
 	return i
 }
 
+func (app *application) readBool(qs url.Values, key string, defaultValue bool, v *validator.Validator) bool {
+	s := qs.Get(key)
+	if s == "" {
+		return defaultValue
+	}
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		v.AddError(key, "must be a boolean value")
+		return defaultValue
+	}
+
+	return b
+}
+
+// readOptionalBool is readBool for filters that can be left out, nil when the key isn't in the query string
+func (app *application) readOptionalBool(qs url.Values, key string, v *validator.Validator) *bool {
+	if qs.Get(key) == "" {
+		return nil
+	}
+
+	b := app.readBool(qs, key, false, v)
+	return &b
+}
+
+// httprouter doesn't allow a static segment to live next to a wildcard (e.g /v1/movies/suggest and /v1/movies/:id),
+// so the wildcard route hands the reserved values over to their own handlers and everything else to next
+func (app *application) dispatchParam(name string, handlers map[string]http.HandlerFunc, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		params := httprouter.ParamsFromContext(r.Context())
+		if handler, ok := handlers[params.ByName(name)]; ok {
+			handler(w, r)
+			return
+		}
+		next(w, r)
+	}
+}
+
 func (app *application) readIDParam(r *http.Request) (int64, error) {
 	params := httprouter.ParamsFromContext(r.Context())
 	// below means, convert the id string(all values in params are strings ) to a base 10 64 bit interger
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// keyedLimiter is a token bucket per key, like an email address, for limits that the per-IP
+// rateLimit middleware can't express. keys are forgotten once their bucket would be full again
+type keyedLimiter struct {
+	mu      sync.Mutex
+	limit   rate.Limit
+	burst   int
+	idle    time.Duration
+	buckets map[string]*bucket
+}
+
+type bucket struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// newKeyedLimiter allows burst events per key, then one every interval
+func newKeyedLimiter(interval time.Duration, burst int) *keyedLimiter {
+	l := &keyedLimiter{
+		limit:   rate.Every(interval),
+		burst:   burst,
+		idle:    interval * time.Duration(burst),
+		buckets: make(map[string]*bucket),
+	}
+
+	go func() {
+		for {
+			time.Sleep(time.Minute)
+			l.mu.Lock()
+
+			for key, b := range l.buckets {
+				if time.Since(b.lastSeen) > l.idle {
+					delete(l.buckets, key)
+				}
+			}
+
+			l.mu.Unlock()
+		}
+	}()
+
+	return l
+}
+
+func (l *keyedLimiter) Allow(key string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b, found := l.buckets[key]
+	if !found {
+		b = &bucket{limiter: rate.NewLimiter(l.limit, l.burst)}
+		l.buckets[key] = b
+	}
+
+	b.lastSeen = time.Now()
+	return b.limiter.Allow()
+}
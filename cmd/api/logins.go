@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"time"
+
+	"github.com/s-devoe/greenlight-go/internal/data"
+	"github.com/s-devoe/greenlight-go/internal/jsonlog"
+)
+
+// clientIP returns the IP address of the client making r. a request relayed by a trusted proxy is
+// attributed to the rightmost address of X-Forwarded-For that isn't a trusted proxy itself, the
+// addresses left of it were written by the client and can't be relied on
+func (app *application) clientIP(r *http.Request) (string, error) {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return "", err
+	}
+
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return "", err
+	}
+	ip = ip.Unmap()
+
+	if !app.trustedProxy(ip) {
+		return ip.String(), nil
+	}
+
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			break
+		}
+
+		ip = hop.Unmap()
+		if !app.trustedProxy(ip) {
+			break
+		}
+	}
+
+	return ip.String(), nil
+}
+
+func (app *application) trustedProxy(ip netip.Addr) bool {
+	for _, prefix := range app.trustedProxies {
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// loginBlocked returns how long the client has to wait before trying to log in as email again,
+// the longer of the account and the IP blocks
+func (app *application) loginBlocked(r *http.Request, email string) (time.Duration, error) {
+	ip, err := app.clientIP(r)
+	if err != nil {
+		return 0, err
+	}
+
+	wait, err := app.store.Logins.Blocked(r.Context(), data.LoginKindAccount, email)
+	if err != nil {
+		return 0, err
+	}
+
+	ipWait, err := app.store.Logins.Blocked(r.Context(), data.LoginKindIP, ip)
+	if err != nil {
+		return 0, err
+	}
+
+	return max(wait, ipWait), nil
+}
+
+// loginFailed records a failed login for the account and the client IP. user is nil when no account
+// has that email, otherwise the owner is emailed when the attempt locks their account
+func (app *application) loginFailed(r *http.Request, email string, user *data.User) error {
+	ip, err := app.clientIP(r)
+	if err != nil {
+		return err
+	}
+
+	_, err = app.store.Logins.Fail(r.Context(), data.LoginKindIP, ip)
+	if err != nil {
+		return err
+	}
+
+	failure, err := app.store.Logins.Fail(r.Context(), data.LoginKindAccount, email)
+	if err != nil {
+		return err
+	}
+
+	// only the attempt that locks the account sends the email, not every one made while it's locked
+	if user != nil && failure.LockedNow {
+		app.logger.Warn("account locked",
+			jsonlog.String("request_id", requestID(r.Context())),
+			jsonlog.Int64("user_id", user.ID),
+			jsonlog.String("ip", ip),
+		)
+
+		duration := data.LoginLockoutDuration.String()
+		app.background(r.Context(), "account locked email", func(ctx context.Context) {
+			data := map[string]interface{}{
+				"failures": failure.Failures,
+				"duration": duration,
+				"ip":       ip,
+			}
+
+			err := app.mailer.SendMail(ctx, user.Email, "account_locked.tmpl", data)
+			if err != nil {
+				app.logContextError(ctx, err, nil)
+			}
+		})
+	}
+
+	return nil
+}
+
+func (app *application) unlockUserHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	user, err := app.store.Users.Get(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.store.Logins.Reset(r.Context(), data.LoginKindAccount, user.Email)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "account unlocked"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// purgeLoginFailures periodically removes failed logins that no longer count towards anything, until ctx is cancelled
+func (app *application) purgeLoginFailures(ctx context.Context) {
+	ticker := time.NewTicker(10 * time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		err := app.store.Logins.DeleteExpired(ctx)
+		if err != nil && ctx.Err() == nil {
+			app.logger.PrintError(err, nil)
+		}
+	}
+}
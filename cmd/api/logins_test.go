@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/s-devoe/greenlight-go/internal/data"
+)
+
+func TestClientIP(t *testing.T) {
+	app := &application{trustedProxies: []netip.Prefix{
+		netip.MustParsePrefix("10.0.0.0/8"),
+		netip.MustParsePrefix("::1/128"),
+	}}
+
+	tests := []struct {
+		name         string
+		remoteAddr   string
+		forwardedFor []string
+		want         string
+		wantErr      bool
+	}{
+		{name: "direct client", remoteAddr: "203.0.113.7:5100", want: "203.0.113.7"},
+		{name: "direct client can't forge", remoteAddr: "203.0.113.7:5100", forwardedFor: []string{"198.51.100.1"}, want: "203.0.113.7"},
+		{name: "through a proxy", remoteAddr: "10.0.0.2:5100", forwardedFor: []string{"198.51.100.1"}, want: "198.51.100.1"},
+		{name: "through a chain of proxies", remoteAddr: "10.0.0.2:5100", forwardedFor: []string{"198.51.100.1, 10.0.0.3"}, want: "198.51.100.1"},
+		{name: "forged entries on the left", remoteAddr: "10.0.0.2:5100", forwardedFor: []string{"192.0.2.66, 198.51.100.1"}, want: "198.51.100.1"},
+		{name: "several headers", remoteAddr: "10.0.0.2:5100", forwardedFor: []string{"192.0.2.66", "198.51.100.1"}, want: "198.51.100.1"},
+		{name: "proxy without the header", remoteAddr: "10.0.0.2:5100", want: "10.0.0.2"},
+		{name: "garbage in the header", remoteAddr: "10.0.0.2:5100", forwardedFor: []string{"unknown"}, want: "10.0.0.2"},
+		{name: "ipv6 proxy", remoteAddr: "[::1]:5100", forwardedFor: []string{"2001:db8::5"}, want: "2001:db8::5"},
+		{name: "ipv4 mapped", remoteAddr: "[::ffff:10.0.0.2]:5100", forwardedFor: []string{"198.51.100.1"}, want: "198.51.100.1"},
+		{name: "no port", remoteAddr: "203.0.113.7", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tt.remoteAddr
+			for _, value := range tt.forwardedFor {
+				r.Header.Add("X-Forwarded-For", value)
+			}
+
+			got, err := app.clientIP(r)
+			switch {
+			case tt.wantErr && err == nil:
+				t.Errorf("clientIP() = %q, want an error", got)
+			case !tt.wantErr && err != nil:
+				t.Errorf("unexpected error %v", err)
+			case got != tt.want:
+				t.Errorf("clientIP() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoginFailuresLockOnce(t *testing.T) {
+	app := newTestApplication(t)
+
+	const failures = 3 * data.AccountLockoutThreshold
+
+	locked := make(chan bool, failures)
+	var wg sync.WaitGroup
+	for i := 0; i < failures; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			failure, err := app.store.Logins.Fail(context.Background(), data.LoginKindAccount, "dave@example.com")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			locked <- failure.LockedNow
+		}()
+	}
+	wg.Wait()
+	close(locked)
+
+	var lockedNow int
+	for l := range locked {
+		if l {
+			lockedNow++
+		}
+	}
+	if lockedNow != 1 {
+		t.Errorf("%d failures locked the account, want 1", lockedNow)
+	}
+
+	wait, err := app.store.Logins.Blocked(context.Background(), data.LoginKindAccount, "DAVE@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wait < data.LoginLockoutDuration-time.Minute {
+		t.Errorf("blocked for %s, want the %s lockout", wait, data.LoginLockoutDuration)
+	}
+
+	// once unlocked, the next lockout is reported again
+	err = app.store.Logins.Reset(context.Background(), data.LoginKindAccount, "dave@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= data.AccountLockoutThreshold; i++ {
+		failure, err := app.store.Logins.Fail(context.Background(), data.LoginKindAccount, "dave@example.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if failure.LockedNow != (i == data.AccountLockoutThreshold) {
+			t.Errorf("failure %d after the reset: LockedNow = %t", i, failure.LockedNow)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/s-devoe/greenlight-go/internal/data"
+	"github.com/s-devoe/greenlight-go/internal/metrics"
+	"github.com/s-devoe/greenlight-go/internal/validator"
+)
+
+func (app *application) createMagicLinkTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Email string `json:"email"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if data.ValidateEmail(v, input.Email); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// limited whether or not the email belongs to an account, otherwise the limit would give that away
+	if !app.magicLinkLimiter.Allow(strings.ToLower(input.Email)) {
+		metrics.RateLimited.WithLabelValues("magic_link").Inc()
+		app.rateLimitExceededResponse(w, r)
+		return
+	}
+
+	ctx := r.Context()
+
+	env := envelope{"message": "if an account with that email address exists, an email will be sent to it with a login link"}
+
+	user, err := app.store.Users.GetByEmail(ctx, input.Email)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			err = app.writeJSON(w, http.StatusAccepted, env, nil)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+			}
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	token, err := app.store.Tokens.New(ctx, user.ID, data.MagicLinkTokenTTL, data.ScopeMagicLink)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.background(r.Context(), "magic link email", func(ctx context.Context) {
+		data := map[string]interface{}{
+			"magicLinkToken": token.Plaintext,
+		}
+
+		err := app.mailer.SendMail(ctx, user.Email, "magic_link.tmpl", data)
+		if err != nil {
+			app.logContextError(ctx, err, nil)
+		}
+	})
+
+	err = app.writeJSON(w, http.StatusAccepted, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) redeemMagicLinkTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Plaintext string `json:"token"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if data.ValidateToken(v, input.Plaintext); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	ctx := r.Context()
+
+	// the token is consumed along with the login, so of concurrent redeems of a link only one gets a session
+	var status int
+	var env envelope
+	err = app.store.WithTx(ctx, func(tx data.Store) error {
+		userID, err := tx.Tokens.Redeem(ctx, data.ScopeMagicLink, input.Plaintext)
+		if err != nil {
+			return err
+		}
+
+		// the token is single use, and any other link that was sent goes with it
+		err = tx.Tokens.DeleteAllForUser(ctx, data.ScopeMagicLink, userID)
+		if err != nil {
+			return err
+		}
+
+		user, err := tx.Users.Get(ctx, userID)
+		if err != nil {
+			return err
+		}
+
+		// following the link proves the user owns the email address, just like the activation token does
+		if !user.Activated {
+			user.Activated = true
+
+			err = tx.Users.UpdateUser(ctx, user)
+			if err != nil {
+				return err
+			}
+		}
+
+		status, env, err = app.loginTokens(ctx, tx, user)
+		return err
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired login token")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrUpdateConflict):
+			app.updateConflictResponse(w, r)
+		case errors.Is(err, data.ErrAccountDisabled):
+			app.accountDisabledResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, status, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/s-devoe/greenlight-go/internal/data"
+)
+
+func TestRedeemMagicLinkOnce(t *testing.T) {
+	app := newTestApplication(t)
+	user := newTestUser(t, app, "carol@example.com")
+
+	token, err := app.store.Tokens.New(context.Background(), user.ID, data.MagicLinkTokenTTL, data.ScopeMagicLink)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const redeems = 8
+
+	statuses := make(chan int, redeems)
+	var wg sync.WaitGroup
+	for i := 0; i < redeems; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			r := httptest.NewRequest(http.MethodPost, "/v1/tokens/magic-link/redeem", strings.NewReader(`{"token": "`+token.Plaintext+`"}`))
+			rr := httptest.NewRecorder()
+			app.redeemMagicLinkTokenHandler(rr, r)
+
+			statuses <- rr.Code
+		}()
+	}
+	wg.Wait()
+	close(statuses)
+
+	var created int
+	for status := range statuses {
+		switch status {
+		case http.StatusCreated:
+			created++
+		case http.StatusUnprocessableEntity:
+		default:
+			t.Errorf("unexpected status %d", status)
+		}
+	}
+
+	if created != 1 {
+		t.Errorf("the link was redeemed %d times, want once", created)
+	}
+}
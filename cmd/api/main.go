@@ -2,19 +2,31 @@ package main
 
 import (
 	"context"
+	"errors"
 	"expvar"
+	"flag"
 	"log"
+	"log/slog"
+	"net/netip"
 	"os"
 	"runtime"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/multitracer"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/s-devoe/greenlight-go/config"
+	"github.com/s-devoe/greenlight-go/internal/blob"
 	"github.com/s-devoe/greenlight-go/internal/data"
 	"github.com/s-devoe/greenlight-go/internal/jsonlog"
+	"github.com/s-devoe/greenlight-go/internal/jwtauth"
 	"github.com/s-devoe/greenlight-go/internal/mailer"
+	"github.com/s-devoe/greenlight-go/internal/metrics"
+	"github.com/s-devoe/greenlight-go/internal/pwned"
+	"github.com/s-devoe/greenlight-go/internal/sso"
+	"github.com/s-devoe/greenlight-go/internal/tracing"
 )
 
 const version = "1.0.0"
@@ -45,7 +57,22 @@ type application struct {
 	logger *jsonlog.Logger
 	store  data.Store
 	mailer mailer.Mailer
-	wg     sync.WaitGroup
+	blobs  blob.Store
+	jwt    *jwtauth.Issuer // nil unless the JWT authentication mode is enabled
+	sso    map[string]*sso.Provider
+	// trustedProxies are the proxies whose X-Forwarded-For header is believed
+	trustedProxies []netip.Prefix
+	// tokenStates caches what JWTs are checked against, nil unless the JWT authentication mode is enabled
+	tokenStates *tokenStateCache
+	// shutdownTracing flushes the spans not exported yet
+	shutdownTracing func(context.Context) error
+	// magicLinkLimiter limits the login links sent to each email address
+	magicLinkLimiter *keyedLimiter
+	// mailerCheck is the readiness check of the mailer, its outcome is cached
+	mailerCheck *cachedCheck
+	// shuttingDown fails the readiness check while the server drains
+	shuttingDown atomic.Bool
+	wg           sync.WaitGroup
 }
 
 // these are ment to be in .env
@@ -54,21 +81,70 @@ type application struct {
 // )
 
 func main() {
-	cfg := config.InitConfig()
+	cfg, printConfig, err := config.Load(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
+
+	// the configuration is printed even when it's invalid, it helps finding out why
+	if printConfig {
+		printErr := cfg.Print(os.Stdout)
+		if printErr != nil {
+			log.Fatal(printErr)
+		}
+	}
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if printConfig {
+		os.Exit(0)
+	}
 
 	expvar.NewString("version").Set(version)
 	expvar.Publish("goroutines", expvar.Func(func() interface{} {
 		return runtime.NumGoroutine()
 	}))
 
-	logger := jsonlog.New(os.Stdout, jsonlog.LevelInfo)
+	// already validated with the rest of the configuration
+	logLevel, _ := jsonlog.ParseLevel(cfg.LogLevel)
+
+	logger := jsonlog.New(os.Stdout, logLevel)
+	logger.SetSampling(cfg.LogSampleFirst, cfg.LogSampleThereafter, time.Second)
+
+	// libraries logging with log/slog end up in the same JSON log
+	slog.SetDefault(slog.New(logger.Handler()))
+
+	shutdownTracing, err := tracing.Setup(context.Background(), tracing.Config{
+		Exporter:    cfg.TracingExporter,
+		Endpoint:    cfg.TracingEndpoint,
+		ServiceName: "greenlight",
+		Version:     version,
+		SampleRatio: cfg.TracingSampleRatio,
+	})
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
 
-	connPool, err := pgxpool.NewWithConfig(context.Background(), PgxConfig(&cfg))
+	connPool, err := pgxpool.NewWithConfig(context.Background(), PgxConfig(&cfg, cfg.DbSource, logger))
 	if err != nil {
-		log.Fatal("error while connecting to the database ", err)
 		logger.PrintFatal(err, nil)
 	}
 
+	// the replicas aren't required to be up, they're only used once a check finds them healthy
+	var replicaPools []*pgxpool.Pool
+	for _, dsn := range cfg.DBReplicaSources {
+		pool, err := pgxpool.NewWithConfig(context.Background(), PgxConfig(&cfg, dsn, logger))
+		if err != nil {
+			logger.PrintFatal(err, nil)
+		}
+		defer pool.Close()
+		replicaPools = append(replicaPools, pool)
+	}
+	db := data.NewDB(connPool, replicaPools...)
+	go db.MonitorReplicas(context.Background(), cfg.DBReplicaCheckInterval, cfg.DBReplicaMaxLag, logger)
+
 	expvar.Publish("database", expvar.Func(func() interface{} {
 		stats := connPool.Stat()
 
@@ -85,32 +161,89 @@ func main() {
 			"ConstructingConns": stats.ConstructingConns(),
 		}
 	}))
+	metrics.RegisterPool(connPool)
+
 	expvar.Publish("timestamp", expvar.Func(func() interface{} {
 		return time.Now().Unix()
 	}))
 
 	connection, err := connPool.Acquire(context.Background())
 	if err != nil {
-		log.Fatal("error while aquiring connection to the database ", err)
 		logger.PrintFatal(err, nil)
 	}
 
 	err = connection.Ping(context.Background())
 	if err != nil {
-		log.Fatal("Could not ping database")
 		logger.PrintFatal(err, nil)
 	}
+	connection.Release()
 
 	defer connPool.Close()
 
-	log.Printf("Connected to the database %d", cfg.Port)
 	logger.PrintInfo("database connection established", nil)
 
+	timeouts := data.Timeouts{
+		Read:        cfg.DBReadTimeout,
+		Write:       cfg.DBWriteTimeout,
+		List:        cfg.DBListTimeout,
+		Suggest:     cfg.DBSuggestTimeout,
+		Maintenance: cfg.DBMaintenanceTimeout,
+	}
+
+	data.PasswordHashParams.Memory = uint32(cfg.Argon2Memory)
+	data.PasswordHashParams.Iterations = uint32(cfg.Argon2Iterations)
+	data.PasswordHashParams.Parallelism = uint8(cfg.Argon2Parallelism)
+
+	if cfg.PwnedPasswordsPath != "" {
+		data.BreachedPasswords, err = pwned.Open(cfg.PwnedPasswordsPath)
+		if err != nil {
+			logger.PrintFatal(err, nil)
+		}
+	}
+
+	blobs, err := blob.NewLocalStore(cfg.BlobRoot)
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+
+	// already validated with the rest of the configuration
+	trustedProxies, _ := cfg.TrustedProxyPrefixes()
+
 	app := &application{
 		logger: logger,
 		config: cfg,
-		store:  data.NewStore(connPool),
+		store:  data.NewStore(db, timeouts),
 		mailer: mailer.New(cfg.SMTPHost, cfg.SMTPPort, cfg.SMTPUsername, cfg.SMTPPassword, cfg.SMTPSender),
+		blobs:  blobs,
+
+		trustedProxies: trustedProxies,
+
+		shutdownTracing: shutdownTracing,
+
+		magicLinkLimiter: newKeyedLimiter(5*time.Minute, 3),
+	}
+
+	// dialing the SMTP server on every probe would be a lot of connections for a non-critical check
+	app.mailerCheck = newCachedCheck(time.Minute, 5*time.Second, app.mailer.Ping)
+
+	var providers []sso.ProviderConfig
+	for _, provider := range cfg.OIDCProviders {
+		providers = append(providers, sso.ProviderConfig(provider))
+	}
+	app.sso = sso.New(providers)
+
+	if cfg.AuthMode == "jwt" {
+		keys, err := jwtauth.ParseKeys(cfg.JWTAlgorithm, cfg.JWTKeys)
+		if err != nil {
+			logger.PrintFatal(err, nil)
+		}
+
+		app.jwt, err = jwtauth.New(cfg.JWTAlgorithm, keys, cfg.JWTActiveKeyID, cfg.JWTIssuer, data.AccessTokenTTL)
+		if err != nil {
+			logger.PrintFatal(err, nil)
+		}
+
+		app.tokenStates = newTokenStateCache(tokenStateTTL, app.store.Users.GetTokenState)
 	}
 
 	err = app.serve()
@@ -118,38 +251,41 @@ func main() {
 	logger.PrintFatal(err, nil)
 }
 
-func PgxConfig(cfg *config.Config) *pgxpool.Config {
-	const defaultMaxConns = int32(4)
-	const defaultMinConns = int32(0)
-	const defaultMaxConnLifetime = time.Hour
-	const defaultMaxConnIdletime = time.Minute * 30
-	const defaultHealthCheckPeriod = time.Minute
-	const defaultConnecTimeout = time.Second * 50
-
-	dbConfig, err := pgxpool.ParseConfig(cfg.DbSource)
+// PgxConfig is the pool configuration for the primary or one of the replicas, at dsn
+func PgxConfig(cfg *config.Config, dsn string, logger *jsonlog.Logger) *pgxpool.Config {
+	dbConfig, err := pgxpool.ParseConfig(dsn)
 	if err != nil {
-		log.Fatal("failed to create a config, error:", err)
+		logger.PrintFatal(err, nil)
 	}
-	dbConfig.MaxConns = defaultMaxConns
-	dbConfig.MinConns = defaultMinConns
-	dbConfig.MaxConnLifetime = defaultMaxConnLifetime
-	dbConfig.MaxConnIdleTime = defaultMaxConnIdletime
-	dbConfig.HealthCheckPeriod = defaultHealthCheckPeriod
-	dbConfig.ConnConfig.ConnectTimeout = defaultConnecTimeout
+	dbConfig.MaxConns = int32(cfg.DBMaxConns)
+	dbConfig.MinConns = int32(cfg.DBMinConns)
+	dbConfig.MaxConnLifetime = cfg.DBMaxConnLifetime
+	dbConfig.MaxConnIdleTime = cfg.DBMaxConnIdleTime
+	dbConfig.HealthCheckPeriod = cfg.DBHealthCheckPeriod
+	dbConfig.ConnConfig.ConnectTimeout = cfg.DBConnectTimeout
 
+	// pgx takes a single tracer
+	dbConfig.ConnConfig.Tracer = tracing.QueryTracer{}
+	if cfg.DBSlowQueryThreshold > 0 {
+		dbConfig.ConnConfig.Tracer = multitracer.New(
+			tracing.QueryTracer{},
+			data.SlowQueryLogger{Logger: logger, Threshold: cfg.DBSlowQueryThreshold},
+		)
+	}
+
+	// these fire for every query, they're only worth seeing when debugging the pool
 	dbConfig.BeforeAcquire = func(ctx context.Context, c *pgx.Conn) bool {
-		log.Println("Before acquiring the connection pool to the database!!")
+		logger.Debug("acquiring database connection", jsonlog.Int64("pid", int64(c.PgConn().PID())))
 		return true
 	}
 
 	dbConfig.AfterRelease = func(c *pgx.Conn) bool {
-		log.Println("After releasing the connection pool to the database!!")
+		logger.Debug("released database connection", jsonlog.Int64("pid", int64(c.PgConn().PID())))
 		return true
 	}
 
 	dbConfig.BeforeClose = func(c *pgx.Conn) {
-		log.Println("Before closing the connection pool to the database!!")
-
+		logger.Debug("closing database connection", jsonlog.Int64("pid", int64(c.PgConn().PID())))
 	}
 
 	return dbConfig
@@ -1,11 +1,13 @@
 package main
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
 	"expvar"
 	"fmt"
-	"net"
 	"net/http"
+	"regexp"
 	"strconv"
 	"strings"
 	"sync"
@@ -13,10 +15,80 @@ import (
 
 	"github.com/felixge/httpsnoop"
 	"github.com/s-devoe/greenlight-go/internal/data"
+	"github.com/s-devoe/greenlight-go/internal/jsonlog"
+	"github.com/s-devoe/greenlight-go/internal/jwtauth"
+	"github.com/s-devoe/greenlight-go/internal/metrics"
 	"github.com/s-devoe/greenlight-go/internal/validator"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"golang.org/x/time/rate"
 )
 
+// loggedHeaders are the only request headers written to the access log, any other header could
+// carry credentials or personal data
+var loggedHeaders = []string{
+	"User-Agent",
+	"Referer",
+	"Origin",
+	"Accept",
+	"Content-Type",
+	"Content-Length",
+}
+
+// requestIDPattern is what an X-Request-ID sent by the client has to look like to be reused,
+// anything else is replaced so clients can't inject arbitrary text into the logs
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+// logRequest gives every request an id, reusing the X-Request-ID header when the client (or a proxy)
+// sent one, and writes one access log line per request once it's been handled
+func (app *application) logRequest(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !requestIDPattern.MatchString(id) {
+			id = newRequestID()
+		}
+		w.Header().Set("X-Request-ID", id)
+
+		info := &requestInfo{ID: id}
+		r = app.contextSetRequestInfo(r, info)
+		// the reads following a write in this request must not go to a replica
+		r = r.WithContext(data.TrackWrites(r.Context()))
+		trace.SpanFromContext(r.Context()).SetAttributes(attribute.String("request.id", id))
+
+		m := httpsnoop.CaptureMetrics(next, w, r)
+
+		attrs := []jsonlog.Attr{
+			jsonlog.String("request_id", id),
+			jsonlog.String("method", r.Method),
+			jsonlog.String("path", r.URL.Path),
+			jsonlog.String("route", info.Route),
+			jsonlog.Int("status", m.Code),
+			jsonlog.Int64("bytes", m.Written),
+			jsonlog.Float64("duration_ms", float64(m.Duration.Microseconds())/1000),
+		}
+		if info.UserID != 0 {
+			attrs = append(attrs, jsonlog.Int64("user_id", info.UserID))
+		}
+		if ip, err := app.clientIP(r); err == nil {
+			attrs = append(attrs, jsonlog.String("remote_ip", ip))
+		}
+		for _, name := range loggedHeaders {
+			if values := r.Header.Values(name); len(values) > 0 {
+				attrs = append(attrs, jsonlog.String("header."+strings.ToLower(name), strings.Join(values, ", ")))
+			}
+		}
+
+		app.logger.Info("request", attrs...)
+	})
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	// crypto/rand doesn't fail on the platforms we run on
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
 func (app *application) metrics(next http.Handler) http.Handler {
 	totalRequestsReceived := expvar.NewInt("total_requests_received")
 	totalResponsesSent := expvar.NewInt("total_responses_sent")
@@ -26,12 +98,24 @@ func (app *application) metrics(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 
 		totalRequestsReceived.Add(1)
-		metrics := httpsnoop.CaptureMetrics(next, w, r)
+		metrics.HTTPRequestsInFlight.Inc()
 
+		m := httpsnoop.CaptureMetrics(next, w, r)
+
+		metrics.HTTPRequestsInFlight.Dec()
 		totalResponsesSent.Add(1)
 
-		totalProcessingTimeMicroseconds.Add(metrics.Duration.Microseconds())
-		totalResponsesSentByStatus.Add(strconv.Itoa(metrics.Code), 1)
+		totalProcessingTimeMicroseconds.Add(m.Duration.Microseconds())
+		totalResponsesSentByStatus.Add(strconv.Itoa(m.Code), 1)
+
+		// filled in by the router once a route matched, see routePatternRouter
+		route := app.contextGetRequestInfo(r).Route
+		if route == "" {
+			route = "unmatched"
+		}
+		status := strconv.Itoa(m.Code)
+		metrics.HTTPRequests.WithLabelValues(route, r.Method, status).Inc()
+		metrics.HTTPRequestDuration.WithLabelValues(route, r.Method, status).Observe(m.Duration.Seconds())
 	})
 }
 
@@ -39,10 +123,14 @@ func (app *application) requirePermission(code string, next http.HandlerFunc) ht
 	fn := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		user := app.contextGetUser(r)
 
-		permissions, err := app.store.Permissions.GetAllPermissionsForUser(user.ID)
-		if err != nil {
-			app.serverErrorResponse(w, r, err)
-			return
+		permissions, ok := app.contextGetPermissions(r)
+		if !ok {
+			var err error
+			permissions, err = app.store.Permissions.GetAllPermissionsForUser(r.Context(), user.ID)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
 		}
 
 		if !permissions.Include(code) {
@@ -109,6 +197,44 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 		}
 		token := headerParts[1]
 
+		// JWTs are verified locally, only the token state of the user is looked up and it's cached
+		if app.jwt != nil && jwtauth.LooksLikeJWT(token) {
+			userID, claims, err := app.jwt.Verify(token)
+			if err != nil {
+				app.invalidAuthenticationTokenResponse(w, r)
+				return
+			}
+
+			state, err := app.tokenStates.Get(r.Context(), userID)
+			if err != nil {
+				switch {
+				case errors.Is(err, data.ErrRecordNotFound):
+					app.invalidAuthenticationTokenResponse(w, r)
+				default:
+					app.serverErrorResponse(w, r, err)
+				}
+				return
+			}
+
+			if state.Disabled {
+				app.accountDisabledResponse(w, r)
+				return
+			}
+
+			// the user's sessions were revoked since the token was issued
+			if claims.TokenVersion != state.Version {
+				app.invalidAuthenticationTokenResponse(w, r)
+				return
+			}
+
+			r = app.contextSetPermissions(r, data.Permissions(claims.Permissions))
+			r = app.contextSetUser(r, &data.User{ID: userID, Activated: claims.Activated})
+			app.contextGetRequestInfo(r).UserID = userID
+
+			next.ServeHTTP(w, r)
+			return
+		}
+
 		v := validator.New()
 
 		if data.ValidateToken(v, token); !v.Valid() {
@@ -116,18 +242,32 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 			return
 		}
 
-		user, err := app.store.Users.GetForToken(data.ScopeAuthentication, token)
+		user, tokenPermissions, err := app.store.Users.GetForAuthenticationToken(r.Context(), token)
 		if err != nil {
 			switch {
 			case errors.Is(err, data.ErrRecordNotFound):
 				app.invalidAuthenticationTokenResponse(w, r)
+			case errors.Is(err, data.ErrAccountDisabled):
+				app.accountDisabledResponse(w, r)
 			default:
 				app.serverErrorResponse(w, r, err)
 			}
 			return
 		}
 
+		// personal access tokens only carry the part of the user's permissions they were scoped to
+		if tokenPermissions != nil {
+			permissions, err := app.store.Permissions.GetAllPermissionsForUser(r.Context(), user.ID)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+			r = app.contextSetPermissions(r, permissions.Intersect(tokenPermissions))
+			r = app.contextSetTokenScope(r, data.ScopePersonalAccess)
+		}
+
 		r = app.contextSetUser(r, user)
+		app.contextGetRequestInfo(r).UserID = user.ID
 
 		next.ServeHTTP(w, r)
 	})
@@ -173,7 +313,7 @@ func (app *application) rateLimit(next http.Handler) http.Handler {
 
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if app.config.LimiterEnabled {
-			ip, _, err := net.SplitHostPort(r.RemoteAddr)
+			ip, err := app.clientIP(r)
 			if err != nil {
 				app.serverErrorResponse(w, r, err)
 				return
@@ -187,6 +327,7 @@ func (app *application) rateLimit(next http.Handler) http.Handler {
 			clients[ip].lastSeen = time.Now()
 			if !clients[ip].limiter.Allow() {
 				mu.Unlock()
+				metrics.RateLimited.WithLabelValues("ip").Inc()
 				app.rateLimitExceededResponse(w, r)
 				return
 			}
@@ -1,9 +1,13 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
+	"strconv"
 
 	"github.com/s-devoe/greenlight-go/internal/data"
 	"github.com/s-devoe/greenlight-go/internal/validator"
@@ -14,6 +18,8 @@ type CreateMovieRequest struct {
 	Year    int32        `json:"year"`
 	Runtime data.Runtime `json:"runtime"`
 	Genres  []string     `json:"genres"`
+	ImdbID  *string      `json:"imdb_id"`
+	TmdbID  *int64       `json:"tmdb_id"`
 }
 
 func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Request) {
@@ -26,30 +32,57 @@ func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
+	v := validator.New()
+	qs := r.URL.Query()
+
 	movie := &data.Movie{
-		Title:   input.Title,
-		Genres:  input.Genres,
-		Runtime: input.Runtime,
-		Year:    input.Year,
+		Title:         input.Title,
+		Genres:        input.Genres,
+		Runtime:       input.Runtime,
+		Year:          input.Year,
+		ImdbID:        input.ImdbID,
+		TmdbID:        input.TmdbID,
+		RuntimeFormat: app.readRuntimeFormat(qs),
 	}
 
-	v := validator.New()
+	data.ValidateRuntimeFormat(v, movie.RuntimeFormat)
 	if data.ValidateMovie(v, movie); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 	ctx := r.Context()
-	err = app.store.Movies.Insert(ctx, movie)
 
+	// near-identical titles in the same year are reported back, the same title (ignoring case) in the
+	// same year is rejected by the movies_title_year_key unique index
+	duplicates, err := app.store.Movies.FindDuplicates(ctx, movie.Title, movie.Year)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
+	err = app.store.Movies.Insert(ctx, movie)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateMovie):
+			app.duplicateMovieResponse(w, r, duplicates)
+		case errors.Is(err, data.ErrDuplicateExternalID):
+			app.duplicateExternalIDResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
 	headers := make(http.Header)
 	headers.Set("Location", fmt.Sprintf("/v1/movies/%d", movie.ID))
 
-	err = app.writeJSON(w, http.StatusCreated, envelope{"movies": movie}, headers)
+	env := envelope{"movies": movie}
+	if len(duplicates) > 0 {
+		env["possible_duplicates"] = duplicates
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, env, headers)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -61,9 +94,24 @@ func (app *application) showMovieHandler(w http.ResponseWriter, r *http.Request)
 		app.notFoundResponse(w, r)
 		return
 	}
+	v := validator.New()
+	qs := r.URL.Query()
+
+	fields := app.readCSV(qs, "fields", []string{})
+	includes := app.readCSV(qs, "include", []string{})
+	runtimeFormat := app.readRuntimeFormat(qs)
+
+	data.ValidateMovieFields(v, fields)
+	data.ValidateMovieIncludes(v, includes)
+	data.ValidateRuntimeFormat(v, runtimeFormat)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
 	ctx := r.Context()
 
-	movie, err := app.store.Movies.Get(ctx, id)
+	movie, err := app.store.Movies.Get(ctx, id, fields...)
 
 	if err != nil {
 		switch {
@@ -74,6 +122,15 @@ func (app *application) showMovieHandler(w http.ResponseWriter, r *http.Request)
 		}
 		return
 	}
+	movie.RuntimeFormat = runtimeFormat
+
+	if validator.In("credits", includes...) {
+		err = app.store.Movies.LoadCredits(ctx, movie)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
 
 	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, nil)
 	if err != nil {
@@ -87,6 +144,29 @@ type UpdateMovieRequest struct {
 	Year    *int32        `json:"year"`
 	Runtime *data.Runtime `json:"runtime"`
 	Genres  []string      `json:"genres"`
+	// the external ids are cleared with null
+	ImdbID nullable[string] `json:"imdb_id"`
+	TmdbID nullable[int64]  `json:"tmdb_id"`
+}
+
+// nullable is a field of a partial update that can be set to null, unlike a pointer it tells a null
+// value apart from a field that was left out
+type nullable[T any] struct {
+	// Set is true when the field was in the JSON, Value is nil when it was null
+	Set   bool
+	Value *T
+}
+
+func (n *nullable[T]) UnmarshalJSON(b []byte) error {
+	n.Set = true
+
+	if string(b) == "null" {
+		n.Value = nil
+		return nil
+	}
+
+	n.Value = new(T)
+	return json.Unmarshal(b, n.Value)
 }
 
 func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Request) {
@@ -104,7 +184,8 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	ctx := r.Context()
+	// the movie is read from the primary, the update is checked against its version
+	ctx := data.ReadPrimary(r.Context())
 	movie, err := app.store.Movies.Get(ctx, id)
 
 	if err != nil {
@@ -128,8 +209,16 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 	if input.Year != nil {
 		movie.Year = *input.Year
 	}
+	if input.ImdbID.Set {
+		movie.ImdbID = input.ImdbID.Value
+	}
+	if input.TmdbID.Set {
+		movie.TmdbID = input.TmdbID.Value
+	}
+	movie.RuntimeFormat = app.readRuntimeFormat(r.URL.Query())
 
 	v := validator.New()
+	data.ValidateRuntimeFormat(v, movie.RuntimeFormat)
 	if data.ValidateMovie(v, movie); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
@@ -140,6 +229,10 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 		switch {
 		case errors.Is(err, data.ErrUpdateConflict):
 			app.updateConflictResponse(w, r)
+		case errors.Is(err, data.ErrDuplicateMovie):
+			app.duplicateMovieResponse(w, r, nil)
+		case errors.Is(err, data.ErrDuplicateExternalID):
+			app.duplicateExternalIDResponse(w, r)
 		default:
 
 			app.serverErrorResponse(w, r, err)
@@ -173,6 +266,13 @@ func (app *application) deleteMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
+	app.background(r.Context(), "delete posters", func(ctx context.Context) {
+		err := app.blobs.DeletePrefix(ctx, data.PosterPrefix(id))
+		if err != nil {
+			app.logContextError(ctx, err, map[string]string{"movie_id": strconv.FormatInt(id, 10)})
+		}
+	})
+
 	err = app.writeJSON(w, http.StatusOK, envelope{"message": "Movie deleted successfully"}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
@@ -180,9 +280,13 @@ func (app *application) deleteMovieHandler(w http.ResponseWriter, r *http.Reques
 }
 
 type listMovieParams struct {
-	Title        string
-	Genres       []string
-	data.Filters // add the pagination types here
+	Title         string
+	Genres        []string
+	Fuzzy         bool
+	Fields        []string
+	Includes      []string
+	RuntimeFormat data.RuntimeFormat
+	data.Filters  // add the pagination types here
 }
 
 func (app *application) listMoviesHandler(w http.ResponseWriter, r *http.Request) {
@@ -193,11 +297,19 @@ func (app *application) listMoviesHandler(w http.ResponseWriter, r *http.Request
 
 	params.Title = app.readString(qs, "title", "")
 	params.Genres = app.readCSV(qs, "genres", []string{})
+	params.Fuzzy = app.readBool(qs, "fuzzy", false, v)
+	params.Fields = app.readCSV(qs, "fields", []string{})
+	params.Includes = app.readCSV(qs, "include", []string{})
+	params.RuntimeFormat = app.readRuntimeFormat(qs)
 	params.Filters.Page = app.readInt(qs, "page", 1, v)
 	params.Filters.PageSize = app.readInt(qs, "page_size", 10, v)
 	params.Filters.Sort = app.readString(qs, "sort", "id")
 	params.Filters.SortSafeList = []string{"id", "title", "year", "runtime", "-id", "-title", "-runtime", "-year"}
 
+	data.ValidateMovieFields(v, params.Fields)
+	data.ValidateMovieIncludes(v, params.Includes)
+	data.ValidateRuntimeFormat(v, params.RuntimeFormat)
+
 	if data.ValidateFilters(v, params.Filters); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
@@ -205,12 +317,24 @@ func (app *application) listMoviesHandler(w http.ResponseWriter, r *http.Request
 
 	ctx := r.Context()
 
-	movies, metadata, err := app.store.Movies.GetAll(ctx, params.Title, params.Genres, params.Filters)
+	movies, metadata, err := app.store.Movies.GetAll(ctx, params.Title, params.Genres, params.Fuzzy, params.Fields, params.Filters)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
+	for _, movie := range movies {
+		movie.RuntimeFormat = params.RuntimeFormat
+	}
+
+	if validator.In("credits", params.Includes...) {
+		err = app.store.Movies.LoadCredits(ctx, movies...)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
 	err = app.writeJSON(w, http.StatusOK, envelope{"movies": movies, "metadata": metadata}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
@@ -218,3 +342,80 @@ func (app *application) listMoviesHandler(w http.ResponseWriter, r *http.Request
 	}
 
 }
+
+// readRuntimeFormat reads the runtime_format query string parameter, the "<n> mins" format is the default
+func (app *application) readRuntimeFormat(qs url.Values) data.RuntimeFormat {
+	return data.RuntimeFormat(app.readString(qs, "runtime_format", string(data.RuntimeFormatMins)))
+}
+
+func (app *application) suggestMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	qs := r.URL.Query()
+
+	prefix := app.readString(qs, "prefix", "")
+	limit := app.readInt(qs, "limit", 5, v)
+
+	v.Check(prefix != "", "prefix", "must be provided")
+	v.Check(len(prefix) <= 100, "prefix", "must not be more than 100 characters")
+	v.Check(limit > 0, "limit", "must be greater than zero")
+	v.Check(limit <= 20, "limit", "must be lesser than or equal to 20")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	suggestions, err := app.store.Movies.Suggest(r.Context(), prefix, limit)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"suggestions": suggestions}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) lookupMovieHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	qs := r.URL.Query()
+
+	imdbID := app.readString(qs, "imdb", "")
+	tmdbID := app.readInt(qs, "tmdb", 0, v)
+
+	v.Check(imdbID != "" || tmdbID != 0, "imdb", "an imdb or tmdb id must be provided")
+	v.Check(imdbID == "" || tmdbID == 0, "imdb", "only one of imdb or tmdb must be provided")
+
+	var source string
+	var externalID interface{}
+	switch {
+	case imdbID != "":
+		source, externalID = "imdb", imdbID
+		data.ValidateImdbID(v, imdbID)
+	default:
+		source, externalID = "tmdb", int64(tmdbID)
+		data.ValidateTmdbID(v, int64(tmdbID))
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	movie, err := app.store.Movies.GetByExternalID(r.Context(), source, externalID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
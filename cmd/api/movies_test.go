@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestUpdateMovieRequestNullable(t *testing.T) {
+	tests := []struct {
+		body      string
+		imdbSet   bool
+		imdbValue string
+		tmdbSet   bool
+	}{
+		{body: `{}`},
+		{body: `{"imdb_id": null}`, imdbSet: true},
+		{body: `{"imdb_id": "tt0068646"}`, imdbSet: true, imdbValue: "tt0068646"},
+		{body: `{"tmdb_id": null, "imdb_id": null}`, imdbSet: true, tmdbSet: true},
+	}
+
+	for _, tt := range tests {
+		var input UpdateMovieRequest
+		err := json.Unmarshal([]byte(tt.body), &input)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.body, err)
+		}
+
+		if input.ImdbID.Set != tt.imdbSet || input.TmdbID.Set != tt.tmdbSet {
+			t.Errorf("%s: imdb_id set %t, tmdb_id set %t", tt.body, input.ImdbID.Set, input.TmdbID.Set)
+		}
+
+		var imdbValue string
+		if input.ImdbID.Value != nil {
+			imdbValue = *input.ImdbID.Value
+		}
+		if imdbValue != tt.imdbValue {
+			t.Errorf("%s: imdb_id = %q, want %q", tt.body, imdbValue, tt.imdbValue)
+		}
+		if input.TmdbID.Value != nil {
+			t.Errorf("%s: tmdb_id = %d, want nil", tt.body, *input.TmdbID.Value)
+		}
+	}
+
+	var input UpdateMovieRequest
+	if err := json.Unmarshal([]byte(`{"tmdb_id": "238"}`), &input); err == nil {
+		t.Error("a string tmdb_id was accepted")
+	}
+}
+
+func TestCreateMovieDuplicates(t *testing.T) {
+	app := newTestApplication(t)
+
+	const creates = 6
+
+	statuses := make(chan int, creates)
+	var wg sync.WaitGroup
+	for i := 0; i < creates; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			// differently cased titles are the same movie
+			title := "The Godfather"
+			if i%2 == 1 {
+				title = "the godfather"
+			}
+
+			body := `{"title": "` + title + `", "year": 1972, "runtime": "175 mins", "genres": ["crime", "drama"]}`
+			r := httptest.NewRequest(http.MethodPost, "/v1/movies", strings.NewReader(body))
+			rr := httptest.NewRecorder()
+			app.createMovieHandler(rr, r)
+
+			statuses <- rr.Code
+		}(i)
+	}
+	wg.Wait()
+	close(statuses)
+
+	var created int
+	for status := range statuses {
+		switch status {
+		case http.StatusCreated:
+			created++
+		case http.StatusConflict:
+		default:
+			t.Errorf("unexpected status %d", status)
+		}
+	}
+
+	if created != 1 {
+		t.Errorf("the movie was created %d times, want once", created)
+	}
+}
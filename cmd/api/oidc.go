@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/s-devoe/greenlight-go/internal/data"
+	"github.com/s-devoe/greenlight-go/internal/sso"
+	"github.com/s-devoe/greenlight-go/internal/validator"
+)
+
+// users have 10 minutes to log in with the identity provider
+const oidcLoginTTL = 10 * time.Minute
+
+func (app *application) oidcLoginHandler(w http.ResponseWriter, r *http.Request) {
+	name := httprouter.ParamsFromContext(r.Context()).ByName("provider")
+
+	provider, ok := app.sso[name]
+	if !ok {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	url, err := app.oidcAuthCodeURL(r.Context(), name, provider, 0)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	http.Redirect(w, r, url, http.StatusFound)
+}
+
+// linkIdentityHandler starts linking an identity of the provider to the logged in user. the url
+// is returned rather than redirected to, the request carries an Authorization header a browser
+// navigation can't
+func (app *application) linkIdentityHandler(w http.ResponseWriter, r *http.Request) {
+	name := httprouter.ParamsFromContext(r.Context()).ByName("provider")
+
+	provider, ok := app.sso[name]
+	if !ok {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	// the identity could then be used to log in with the user's full permissions
+	if app.contextGetTokenScope(r) == data.ScopePersonalAccess {
+		app.personalAccessTokenNotAllowedResponse(w, r)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	url, err := app.oidcAuthCodeURL(r.Context(), name, provider, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"authorization_url": url}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// oidcAuthCodeURL stores a new login state and returns the url of the provider to send the user to
+func (app *application) oidcAuthCodeURL(ctx context.Context, name string, provider *sso.Provider, userID int64) (string, error) {
+	state, err := app.store.Identities.NewLoginState(ctx, name, userID, oidcLoginTTL)
+	if err != nil {
+		return "", err
+	}
+
+	return provider.AuthCodeURL(ctx, state.State, state.Nonce, state.CodeVerifier)
+}
+
+func (app *application) oidcCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	name := httprouter.ParamsFromContext(r.Context()).ByName("provider")
+
+	provider, ok := app.sso[name]
+	if !ok {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	qs := r.URL.Query()
+	if providerError := qs.Get("error"); providerError != "" {
+		app.badRequestResponse(w, r, errors.New("the identity provider returned an error: "+providerError))
+		return
+	}
+
+	code := app.readString(qs, "code", "")
+	state := app.readString(qs, "state", "")
+
+	v := validator.New()
+	v.Check(code != "", "code", "must be provided")
+	v.Check(state != "", "state", "must be provided")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	ctx := r.Context()
+
+	loginState, err := app.store.Identities.ConsumeLoginState(ctx, name, state)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.badRequestResponse(w, r, errors.New("invalid or expired login state"))
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	identity, err := provider.Exchange(ctx, code, loginState.Nonce, loginState.CodeVerifier)
+	if err != nil {
+		switch {
+		case errors.Is(err, sso.ErrEmailNotVerified):
+			app.unverifiedIdentityResponse(w, r)
+		default:
+			app.logError(r, err)
+			app.invalidCredentialsResponse(w, r)
+		}
+		return
+	}
+
+	if loginState.UserID != 0 {
+		err = app.linkIdentity(ctx, name, identity, loginState.UserID)
+		if err != nil {
+			switch {
+			case errors.Is(err, errIdentityLinkedElsewhere):
+				app.identityLinkedElsewhereResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		err = app.writeJSON(w, http.StatusOK, envelope{"message": "the identity was linked to your account"}, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	user, err := app.userForIdentity(ctx, name, identity)
+	if err != nil {
+		switch {
+		case errors.Is(err, errIdentityNotLinked):
+			app.identityNotLinkedResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// the identity provider only stands in for the password, two-factor authentication still applies
+	app.loginResponse(w, r, user)
+}
+
+var (
+	errIdentityNotLinked       = errors.New("an account with the identity's email address exists but the identity isn't linked to it")
+	errIdentityLinkedElsewhere = errors.New("the identity is linked to another account")
+)
+
+// userForIdentity returns the user an external identity belongs to, identities seen for the first
+// time provision a new user. an existing account is never taken over by an identity with the same
+// email address, the identity has to be linked from the account first and errIdentityNotLinked is
+// returned until it is
+func (app *application) userForIdentity(ctx context.Context, provider string, identity *sso.Identity) (*data.User, error) {
+	user, err := app.store.Identities.GetUser(ctx, provider, identity.Subject)
+	if err == nil {
+		return user, nil
+	}
+	if !errors.Is(err, data.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	// the user is provisioned along with the link, or not at all
+	err = app.store.WithTx(ctx, func(tx data.Store) error {
+		_, err := tx.Users.GetByEmail(ctx, identity.Email)
+		switch {
+		case err == nil:
+			return errIdentityNotLinked
+		case !errors.Is(err, data.ErrRecordNotFound):
+			return err
+		}
+
+		user = &data.User{
+			Name:      identity.Name,
+			Email:     identity.Email,
+			Activated: true,
+		}
+		if user.Name == "" {
+			user.Name = identity.Email
+		}
+
+		err = user.Password.SetRandom()
+		if err != nil {
+			return err
+		}
+
+		err = tx.Users.Insert(ctx, user)
+		if err != nil {
+			return err
+		}
+
+		err = tx.Permissions.AddPermissionsForUser(ctx, user.ID, "movies:read")
+		if err != nil {
+			return err
+		}
+
+		return tx.Identities.Link(ctx, provider, identity.Subject, user.ID)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// linkIdentity ties an external identity to the user who started linking it. linking it again is a
+// no-op, errIdentityLinkedElsewhere is returned when it already belongs to someone else
+func (app *application) linkIdentity(ctx context.Context, provider string, identity *sso.Identity, userID int64) error {
+	return app.store.WithTx(ctx, func(tx data.Store) error {
+		user, err := tx.Identities.GetUser(ctx, provider, identity.Subject)
+		switch {
+		case err == nil && user.ID == userID:
+			return nil
+		case err == nil:
+			return errIdentityLinkedElsewhere
+		case !errors.Is(err, data.ErrRecordNotFound):
+			return err
+		}
+
+		return tx.Identities.Link(ctx, provider, identity.Subject, userID)
+	})
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/s-devoe/greenlight-go/internal/data"
+	"github.com/s-devoe/greenlight-go/internal/sso"
+)
+
+func TestUserForIdentityProvisions(t *testing.T) {
+	app := newTestApplication(t)
+	ctx := context.Background()
+
+	identity := &sso.Identity{Subject: "subject-1", Email: "carol@example.com", Name: "Carol"}
+
+	user, err := app.userForIdentity(ctx, "example", identity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !user.Activated || user.Email != identity.Email {
+		t.Errorf("provisioned user %+v, want an activated user with the identity's email", user)
+	}
+
+	linked, err := app.store.Identities.GetUser(ctx, "example", identity.Subject)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if linked.ID != user.ID {
+		t.Errorf("identity linked to user %d, want %d", linked.ID, user.ID)
+	}
+
+	again, err := app.userForIdentity(ctx, "example", identity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.ID != user.ID {
+		t.Errorf("second login got user %d, want %d", again.ID, user.ID)
+	}
+}
+
+func TestUserForIdentityRefusesExistingEmail(t *testing.T) {
+	app := newTestApplication(t)
+	existing := newTestUser(t, app, "dave@example.com")
+	ctx := context.Background()
+
+	// an identity provider vouching for an email address isn't enough to take over the account using it
+	identity := &sso.Identity{Subject: "subject-2", Email: existing.Email, Name: "Mallory"}
+
+	_, err := app.userForIdentity(ctx, "example", identity)
+	if !errors.Is(err, errIdentityNotLinked) {
+		t.Fatalf("got error %v, want %v", err, errIdentityNotLinked)
+	}
+
+	_, err = app.store.Identities.GetUser(ctx, "example", identity.Subject)
+	if !errors.Is(err, data.ErrRecordNotFound) {
+		t.Errorf("identity lookup got %v, want %v, the identity mustn't be linked", err, data.ErrRecordNotFound)
+	}
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/s-devoe/greenlight-go/internal/data"
+	"github.com/s-devoe/greenlight-go/internal/validator"
+)
+
+func (app *application) createPasswordResetTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Email string `json:"email"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if data.ValidateEmail(v, input.Email); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	ctx := r.Context()
+
+	// the response is the same whether or not the email belongs to an account, so it can't be used to find out
+	env := envelope{"message": "if an account with that email address exists, an email will be sent to it with password reset instructions"}
+
+	user, err := app.store.Users.GetByEmail(ctx, input.Email)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			err = app.writeJSON(w, http.StatusAccepted, env, nil)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+			}
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	token, err := app.store.Tokens.New(ctx, user.ID, data.PasswordResetTokenTTL, data.ScopePasswordReset)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.background(r.Context(), "password reset email", func(ctx context.Context) {
+		data := map[string]interface{}{
+			"passwordResetToken": token.Plaintext,
+		}
+
+		err := app.mailer.SendMail(ctx, user.Email, "password_reset.tmpl", data)
+		if err != nil {
+			app.logContextError(ctx, err, nil)
+		}
+	})
+
+	err = app.writeJSON(w, http.StatusAccepted, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// resetUserPasswordHandler sets a new password with a token from createPasswordResetTokenHandler
+func (app *application) resetUserPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Password  string `json:"password"`
+		Plaintext string `json:"token"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	data.ValidateNewPassword(v, input.Password)
+	data.ValidateToken(v, input.Plaintext)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	ctx := r.Context()
+
+	var user *data.User
+	err = app.store.WithTx(ctx, func(tx data.Store) error {
+		var err error
+		user, err = tx.Users.GetForToken(ctx, data.ScopePasswordReset, input.Plaintext)
+		if err != nil {
+			return err
+		}
+
+		err = app.setUserPassword(ctx, tx, user, input.Password)
+		if err != nil {
+			return err
+		}
+
+		// whoever knew the old password is logged out, personal access tokens they could have created
+		// included, and the account is unlocked since the owner proved they have access to the email
+		// address. the reset token goes along with the other sessions
+		err = app.revokeSessions(ctx, tx, user.ID)
+		if err != nil {
+			return err
+		}
+
+		return tx.Logins.Reset(ctx, data.LoginKindAccount, user.Email)
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired password reset token")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrUpdateConflict):
+			app.updateConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.forgetTokenState(user.ID)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "your password was successfully reset"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// changeUserPasswordHandler lets a logged in user change their password, given the current one.
+// the user is logged out everywhere else and the response carries new tokens for this session
+func (app *application) changeUserPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		CurrentPassword string `json:"current_password"`
+		NewPassword     string `json:"new_password"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if app.contextGetTokenScope(r) == data.ScopePersonalAccess {
+		app.personalAccessTokenNotAllowedResponse(w, r)
+		return
+	}
+
+	v := validator.New()
+	v.Check(input.CurrentPassword != "", "current_password", "current password must be provided")
+	data.ValidateNewPassword(v, input.NewPassword)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	ctx := r.Context()
+
+	user, err := app.store.Users.Get(ctx, app.contextGetUser(r).ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// the current password is throttled like a login, otherwise a stolen session could guess it freely
+	wait, err := app.loginBlocked(r, user.Email)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if wait > 0 {
+		app.loginBlockedResponse(w, r, wait)
+		return
+	}
+
+	match, err := user.Password.Matches(input.CurrentPassword)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !match {
+		err = app.loginFailed(r, user.Email, user)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		v.AddError("current_password", "current password is incorrect")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// every session is revoked along with the change, this one and personal access tokens included, and
+	// the client gets a new pair of tokens to carry on with
+	var token, refreshToken *data.Token
+	err = app.store.WithTx(ctx, func(tx data.Store) error {
+		current, err := tx.Users.Get(ctx, user.ID)
+		if err != nil {
+			return err
+		}
+
+		// the password was changed since it was checked
+		if current.Version != user.Version {
+			return data.ErrUpdateConflict
+		}
+
+		err = app.setUserPassword(ctx, tx, current, input.NewPassword)
+		if err != nil {
+			return err
+		}
+
+		err = app.revokeSessions(ctx, tx, current.ID)
+		if err != nil {
+			return err
+		}
+
+		err = tx.Logins.Reset(ctx, data.LoginKindAccount, current.Email)
+		if err != nil {
+			return err
+		}
+
+		token, refreshToken, err = app.newAuthenticationTokens(ctx, tx, current, "")
+		return err
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrUpdateConflict):
+			app.updateConflictResponse(w, r)
+		case errors.Is(err, data.ErrAccountDisabled):
+			app.accountDisabledResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.forgetTokenState(user.ID)
+
+	env := envelope{
+		"message":              "your password was successfully changed, you were logged out of every other session and your personal access tokens were revoked",
+		"authentication_token": token,
+		"refresh_token":        refreshToken,
+	}
+
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// setUserPassword hashes and saves, with store, a password that has already been validated with data.ValidateNewPassword
+func (app *application) setUserPassword(ctx context.Context, store data.Store, user *data.User, password string) error {
+	err := user.Password.Set(password)
+	if err != nil {
+		return err
+	}
+
+	return store.Users.UpdateUser(ctx, user)
+}
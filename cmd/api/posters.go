@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"net/http"
+
+	"github.com/s-devoe/greenlight-go/internal/blob"
+	"github.com/s-devoe/greenlight-go/internal/data"
+	"github.com/s-devoe/greenlight-go/internal/images"
+	"github.com/s-devoe/greenlight-go/internal/validator"
+)
+
+// posters are limited to 10MB
+const maxPosterBytes = 10 << 20
+
+// posterDecodes bounds the number of posters decoded at once, each taking up to 96MB once decoded
+var posterDecodes = make(chan struct{}, 2)
+
+func (app *application) uploadMoviePosterHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxPosterBytes)
+	err = r.ParseMultipartForm(maxPosterBytes)
+	if err != nil {
+		app.badRequestResponse(w, r, fmt.Errorf("body must be a multipart form not larger than %d bytes", maxPosterBytes))
+		return
+	}
+
+	file, _, err := r.FormFile("poster")
+	if err != nil {
+		switch {
+		case errors.Is(err, http.ErrMissingFile):
+			app.badRequestResponse(w, r, errors.New("poster file must be provided"))
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	defer file.Close()
+
+	// the movie is read from the primary, the update is checked against its version
+	ctx := data.ReadPrimary(r.Context())
+
+	movie, err := app.store.Movies.Get(ctx, id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	v := validator.New()
+
+	format, cfg, err := images.DecodeConfig(file)
+	if err != nil {
+		switch {
+		case errors.Is(err, images.ErrUnsupportedFormat):
+			v.AddError("poster", "must be a JPEG or PNG image")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.badRequestResponse(w, r, errors.New("poster must be a valid image"))
+		}
+		return
+	}
+
+	// the dimensions are checked before the image is decoded, a small file can describe a huge image
+	if data.ValidatePoster(v, format, cfg.Width, cfg.Height); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	select {
+	case posterDecodes <- struct{}{}:
+	case <-ctx.Done():
+		app.serverErrorResponse(w, r, ctx.Err())
+		return
+	}
+	img, err := images.Decode(file)
+	<-posterDecodes
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("poster must be a valid image"))
+		return
+	}
+
+	// the new poster is written under its own keys, the blobs of the current one are only
+	// removed once the movie points at the new poster
+	posterID := data.NewPosterID()
+
+	err = app.storePoster(r, movie.ID, posterID, format, img, file)
+	if err != nil {
+		app.deletePoster(r, movie.ID, posterID, format)
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	previousID, previousFormat := movie.PosterID, movie.PosterFormat
+	movie.PosterID, movie.PosterFormat = posterID, format
+
+	err = app.store.Movies.Update(ctx, movie)
+	if err != nil {
+		app.deletePoster(r, movie.ID, posterID, format)
+		switch {
+		case errors.Is(err, data.ErrUpdateConflict):
+			app.updateConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if previousFormat != "" {
+		app.deletePoster(r, movie.ID, previousID, previousFormat)
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showMoviePosterHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	v := validator.New()
+	size := app.readString(r.URL.Query(), "size", "medium")
+
+	if data.ValidatePosterSize(v, size); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	ctx := r.Context()
+
+	movie, err := app.store.Movies.Get(ctx, id, "poster_url")
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if movie.PosterFormat == "" {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	poster, err := app.blobs.Get(ctx, data.PosterKey(movie.ID, movie.PosterID, size, movie.PosterFormat))
+	if err != nil {
+		switch {
+		case errors.Is(err, blob.ErrNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	defer poster.Close()
+
+	w.Header().Set("Content-Type", images.ContentType(movie.PosterFormat))
+	w.Header().Set("Cache-Control", "private, max-age=300")
+	w.WriteHeader(http.StatusOK)
+
+	_, err = io.Copy(w, poster)
+	if err != nil {
+		app.logError(r, err)
+	}
+}
+
+// storePoster writes the thumbnails and the original of an uploaded poster to the blob store
+func (app *application) storePoster(r *http.Request, movieID int64, posterID, format string, img image.Image, file io.ReadSeeker) error {
+	ctx := r.Context()
+
+	for size, width := range data.PosterWidths {
+		var buf bytes.Buffer
+
+		err := images.Encode(&buf, images.Thumbnail(img, width), format)
+		if err != nil {
+			return err
+		}
+
+		err = app.blobs.Put(ctx, data.PosterKey(movieID, posterID, size, format), &buf)
+		if err != nil {
+			return err
+		}
+	}
+
+	// the original is stored exactly as it was uploaded
+	_, err := file.Seek(0, io.SeekStart)
+	if err != nil {
+		return err
+	}
+
+	return app.blobs.Put(ctx, data.PosterKey(movieID, posterID, data.PosterSizeOriginal, format), file)
+}
+
+// deletePoster removes every size of a poster from the blob store. failures only leave
+// unreachable blobs behind, so they are logged and not returned
+func (app *application) deletePoster(r *http.Request, movieID int64, posterID, format string) {
+	for _, size := range data.PosterSizeSafeList {
+		err := app.blobs.Delete(r.Context(), data.PosterKey(movieID, posterID, size, format))
+		if err != nil {
+			app.logError(r, err)
+		}
+	}
+}
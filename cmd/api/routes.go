@@ -1,33 +1,93 @@
 package main
 
 import (
-	"expvar"
 	"net/http"
 
 	"github.com/julienschmidt/httprouter"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
 )
 
 func (app *application) routes() http.Handler {
-	router := httprouter.New()
+	router := routePatternRouter{Router: httprouter.New(), app: app}
 
 	router.NotFound = http.HandlerFunc(app.notFoundResponse)
 	router.MethodNotAllowed = http.HandlerFunc(app.methodNotAllowedResponse)
 
 	router.HandlerFunc(http.MethodGet, "/v1/healthcheck", app.healthcheckHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/health/live", app.livenessHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/health/ready", app.readinessHandler)
 	// movies
 	router.HandlerFunc(http.MethodGet, "/v1/movies", app.requirePermission("movies:read", app.listMoviesHandler))
 	router.HandlerFunc(http.MethodPost, "/v1/movies", app.requirePermission("movies:write", app.createMovieHandler))
-	router.HandlerFunc(http.MethodGet, "/v1/movies/:id", app.requirePermission("movies:read", app.showMovieHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id", app.dispatchParam("id", map[string]http.HandlerFunc{
+		"suggest": app.requirePermission("movies:read", app.suggestMoviesHandler),
+		"lookup":  app.requirePermission("movies:read", app.lookupMovieHandler),
+	}, app.requirePermission("movies:read", app.showMovieHandler)))
 	router.HandlerFunc(http.MethodPatch, "/v1/movies/:id", app.requirePermission("movies:write", app.updateMovieHandler))
 	router.HandlerFunc(http.MethodDelete, "/v1/movies/:id", app.requirePermission("movies:write", app.deleteMovieHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/movies/:id/poster", app.requirePermission("movies:write", app.uploadMoviePosterHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id/poster", app.requirePermission("movies:read", app.showMoviePosterHandler))
 	// users
 	router.HandlerFunc(http.MethodPost, "/v1/users", app.registerUserHandler)
 	router.HandlerFunc(http.MethodPost, "/v1/users/resend-token", app.resendActivationTokenHandler)
 	router.HandlerFunc(http.MethodPut, "/v1/users/activate", app.activateUserHandler)
+	router.HandlerFunc(http.MethodPut, "/v1/users/password", app.resetUserPasswordHandler)
+	router.HandlerFunc(http.MethodPut, "/v1/users/me/password", app.requireActivatedUser(app.changeUserPasswordHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/users/totp", app.requireActivatedUser(app.enrollTOTPHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/users/totp/verify", app.requireActivatedUser(app.verifyTOTPHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/totp", app.requireActivatedUser(app.disableTOTPHandler))
 	// auth-token
 	router.HandlerFunc(http.MethodPost, "/v1/token/auth", app.createAuthenticationTokenHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/refresh", app.refreshAuthenticationTokenHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/password-reset", app.createPasswordResetTokenHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/magic-link", app.createMagicLinkTokenHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/magic-link/redeem", app.redeemMagicLinkTokenHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/mfa", app.createMFAAuthenticationTokenHandler)
+	router.HandlerFunc(http.MethodGet, "/.well-known/jwks.json", app.jwksHandler)
+	// single sign-on
+	router.HandlerFunc(http.MethodGet, "/v1/oidc/:provider/login", app.oidcLoginHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/oidc/:provider/callback", app.oidcCallbackHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/users/me/identities/:provider", app.requireActivatedUser(app.linkIdentityHandler))
+	// personal access tokens
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/personal", app.requireActivatedUser(app.createPersonalAccessTokenHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/tokens/personal", app.requireActivatedUser(app.listPersonalAccessTokensHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/tokens/personal/:id", app.requireActivatedUser(app.deletePersonalAccessTokenHandler))
+	// admin
+	router.HandlerFunc(http.MethodGet, "/v1/admin/users", app.requirePermission("users:admin", app.listUsersHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/admin/users/:id", app.requirePermission("users:admin", app.showUserHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/admin/users/:id", app.requirePermission("users:admin", app.adminUpdateUserHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/admin/users/:id", app.requirePermission("users:admin", app.deleteUserHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/admin/users/:id/password-reset", app.requirePermission("users:admin", app.forcePasswordResetHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/admin/users/:id/lockout", app.requirePermission("users:admin", app.unlockUserHandler))
 
-	router.Handler(http.MethodGet, "/debug/vars", expvar.Handler())
+	// the server span wraps the whole chain, continuing the trace from an incoming traceparent header.
+	// it's named after the method until the router renames it after the matched route
+	return otelhttp.NewHandler(app.logRequest(app.metrics(app.recoverPanic(app.enableCORS(app.rateLimit(app.authenticate(router)))))), "http.server",
+		otelhttp.WithSpanNameFormatter(func(_ string, r *http.Request) string { return r.Method }),
+	)
+}
+
+// routePatternRouter records the pattern of the matched route, like /v1/movies/:id, for the access log,
+// the metrics and the server span, as httprouter doesn't tell
+type routePatternRouter struct {
+	*httprouter.Router
+	app *application
+}
+
+func (rt routePatternRouter) Handler(method, path string, handler http.Handler) {
+	rt.Router.Handler(method, path, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rt.app.contextGetRequestInfo(r).Route = path
+
+		span := trace.SpanFromContext(r.Context())
+		span.SetName(method + " " + path)
+		span.SetAttributes(semconv.HTTPRoute(path))
+
+		handler.ServeHTTP(w, r)
+	}))
+}
 
-	return app.metrics(app.recoverPanic(app.enableCORS(app.rateLimit(app.authenticate(router)))))
+func (rt routePatternRouter) HandlerFunc(method, path string, handler http.HandlerFunc) {
+	rt.Handler(method, path, handler)
 }
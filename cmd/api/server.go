@@ -22,6 +22,28 @@ func (app *application) serve() error {
 		WriteTimeout: 30 * time.Second,
 	}
 
+	// the admin listener is never reachable from outside the host
+	var adminSrv *http.Server
+	if app.config.AdminPort != 0 {
+		adminSrv = &http.Server{
+			Addr:        fmt.Sprintf("127.0.0.1:%d", app.config.AdminPort),
+			Handler:     app.debugRoutes(),
+			ErrorLog:    log.New(app.logger, "", 0),
+			IdleTimeout: time.Minute,
+			ReadTimeout: 10 * time.Second,
+			// no WriteTimeout, CPU profiles and traces take as long as they're asked to
+		}
+	}
+
+	// the maintenance tasks run until the server shuts down
+	maintenanceCtx, stopMaintenance := context.WithCancel(context.Background())
+
+	app.wg.Add(1)
+	go func() {
+		defer app.wg.Done()
+		app.purgeLoginFailures(maintenanceCtx)
+	}()
+
 	// graceful shutdown
 	shutdownError := make(chan error)
 	go func() {
@@ -33,6 +55,12 @@ func (app *application) serve() error {
 		app.logger.PrintInfo("shutting down server", map[string]string{
 			"signal": s.String(),
 		})
+
+		// fail readiness first and keep serving for a while, so load balancers
+		// stop routing here before the listener closes
+		app.shuttingDown.Store(true)
+		time.Sleep(app.config.ShutdownDrainDelay)
+
 		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 		defer cancel()
 
@@ -41,15 +69,41 @@ func (app *application) serve() error {
 			shutdownError <- err
 		}
 
+		// a profile still being taken shouldn't fail the shutdown, it's cut short instead
+		if adminSrv != nil && adminSrv.Shutdown(ctx) != nil {
+			adminSrv.Close()
+		}
+
 		app.logger.PrintInfo("completing background tasks", map[string]string{
 			"addr": srv.Addr,
 		})
 
+		stopMaintenance()
 		app.wg.Wait()
-		shutdownError <- nil
+
+		// the background tasks are done, so are their spans
+		ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		shutdownError <- app.shutdownTracing(ctx)
 
 	}()
 
+	if adminSrv != nil {
+		go func() {
+			app.logger.PrintInfo("starting admin server", map[string]string{
+				"addr": adminSrv.Addr,
+			})
+
+			err := adminSrv.ListenAndServe()
+			if !errors.Is(err, http.ErrServerClosed) {
+				app.logger.PrintError(err, map[string]string{
+					"addr": adminSrv.Addr,
+				})
+			}
+		}()
+	}
+
 	app.logger.PrintInfo("starting server", map[string]string{
 		"env":  app.config.Env,
 		"addr": srv.Addr,
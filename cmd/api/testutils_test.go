@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/s-devoe/greenlight-go/internal/data"
+	"github.com/s-devoe/greenlight-go/internal/jsonlog"
+)
+
+// newTestApplication returns an application backed by the database at GREENLIGHT_TEST_DB_DSN, in a
+// schema of its own with every migration applied, dropped when the test ends. the test is skipped
+// when the variable isn't set
+func newTestApplication(t *testing.T) *application {
+	t.Helper()
+
+	dsn := os.Getenv("GREENLIGHT_TEST_DB_DSN")
+	if dsn == "" {
+		t.Skip("GREENLIGHT_TEST_DB_DSN isn't set")
+	}
+
+	ctx := context.Background()
+
+	admin, err := pgxpool.New(ctx, dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(admin.Close)
+
+	schema := fmt.Sprintf("test_%d", time.Now().UnixNano())
+	_, err = admin.Exec(ctx, "CREATE SCHEMA "+schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_, err := admin.Exec(context.Background(), "DROP SCHEMA "+schema+" CASCADE")
+		if err != nil {
+			t.Error(err)
+		}
+	})
+
+	config, err := pgxpool.ParseConfig(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.ConnConfig.RuntimeParams["search_path"] = schema + ", public"
+
+	pool, err := pgxpool.NewWithConfig(ctx, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(pool.Close)
+
+	migrations := os.DirFS("../../migrations")
+	files, err := fs.Glob(migrations, "*.up.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(files)
+
+	for _, file := range files {
+		stmt, err := fs.ReadFile(migrations, file)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = pool.Exec(ctx, string(stmt))
+		if err != nil {
+			t.Fatalf("%s: %v", file, err)
+		}
+	}
+
+	timeouts := data.Timeouts{
+		Read:        5 * time.Second,
+		Write:       5 * time.Second,
+		List:        5 * time.Second,
+		Suggest:     5 * time.Second,
+		Maintenance: 5 * time.Second,
+	}
+
+	app := &application{
+		logger: jsonlog.New(io.Discard, jsonlog.LevelError),
+		store:  data.NewStore(data.NewDB(pool), timeouts),
+	}
+	t.Cleanup(app.wg.Wait)
+
+	return app
+}
+
+// newTestUser inserts an activated user with the password "pa55word1234"
+func newTestUser(t *testing.T, app *application, email string) *data.User {
+	t.Helper()
+
+	user := &data.User{Name: "Test", Email: email, Activated: true}
+
+	err := user.Password.Set("pa55word1234")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = app.store.Users.Insert(context.Background(), user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return user
+}
+
+// authenticateStatus returns the status of a request carrying token once it went through the authenticate
+// middleware, 200 when the token was accepted
+func authenticateStatus(t *testing.T, app *application, token string) int {
+	t.Helper()
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	r := httptest.NewRequest(http.MethodGet, "/v1/movies", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+
+	rr := httptest.NewRecorder()
+	app.authenticate(next).ServeHTTP(rr, r)
+
+	return rr.Code
+}
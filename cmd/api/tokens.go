@@ -1,11 +1,13 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"net/http"
 	"time"
 
 	"github.com/s-devoe/greenlight-go/internal/data"
+	"github.com/s-devoe/greenlight-go/internal/tracing"
 	"github.com/s-devoe/greenlight-go/internal/validator"
 )
 
@@ -30,10 +32,25 @@ func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
 		return
 	}
 
+	wait, err := app.loginBlocked(r, input.Email)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if wait > 0 {
+		app.loginBlockedResponse(w, r, wait)
+		return
+	}
+
 	user, err := app.store.Users.GetByEmail(r.Context(), input.Email)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
+			err = app.loginFailed(r, input.Email, nil)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
 			app.invalidCredentialsResponse(w, r)
 		default:
 			app.serverErrorResponse(w, r, err)
@@ -41,26 +58,329 @@ func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
 		return
 	}
 
+	// hashing is slow on purpose, it gets its own span to tell it apart from the database time
+	_, span := tracing.Tracer().Start(r.Context(), "password.compare")
 	match, err := user.Password.Matches(input.Password)
+	span.End()
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
 	if !match {
+		err = app.loginFailed(r, input.Email, user)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
 		app.invalidCredentialsResponse(w, r)
 		return
 	}
 
-	token, err := app.store.Tokens.New(r.Context(), user.ID, 24*time.Hour, data.ScopeAuthentication)
+	err = app.store.Logins.Reset(r.Context(), data.LoginKindAccount, input.Email)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// a failure to save the upgraded hash isn't worth failing the login over, it's retried next time
+	err = app.store.Users.UpdatePasswordHash(r.Context(), user)
+	if err != nil {
+		app.logError(r, err)
+	}
+
+	app.loginResponse(w, r, user)
+}
+
+// loginResponse finishes a login once the user proved who they are, with their password, a magic link or
+// an identity provider. with two-factor authentication enabled that only buys a short-lived token to
+// exchange, along with a code, at /v1/tokens/mfa
+func (app *application) loginResponse(w http.ResponseWriter, r *http.Request, user *data.User) {
+	status, env, err := app.loginTokens(r.Context(), app.store, user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrAccountDisabled):
+			app.accountDisabledResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, status, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// loginTokens issues, through store, the tokens loginResponse answers with along with their status, so a
+// login can be finished in a transaction
+func (app *application) loginTokens(ctx context.Context, store data.Store, user *data.User) (int, envelope, error) {
+	if user.Disabled {
+		return 0, nil, data.ErrAccountDisabled
+	}
+
+	setup, err := store.TOTP.Get(ctx, user.ID)
+	switch {
+	case err == nil && setup.Enabled:
+		mfaToken, err := store.Tokens.New(ctx, user.ID, data.MFAPendingTokenTTL, data.ScopeMFAPending)
+		if err != nil {
+			return 0, nil, err
+		}
+		return http.StatusAccepted, envelope{"mfa_required": true, "mfa_token": mfaToken}, nil
+	case err != nil && !errors.Is(err, data.ErrRecordNotFound):
+		return 0, nil, err
+	}
+
+	token, refreshToken, err := app.newAuthenticationTokens(ctx, store, user, "")
+	if err != nil {
+		return 0, nil, err
+	}
+	return http.StatusCreated, envelope{"authentication_token": token, "refresh_token": refreshToken}, nil
+}
+
+// newAuthenticationTokens issues the authentication and refresh tokens returned on login and refresh,
+// through the given store so they can be issued in a transaction. in the jwt mode the authentication
+// token is a signed JWT instead of an opaque token stored in the database
+func (app *application) newAuthenticationTokens(ctx context.Context, store data.Store, user *data.User, family string) (*data.Token, *data.Token, error) {
+	if user.Disabled {
+		return nil, nil, data.ErrAccountDisabled
+	}
+
+	if app.jwt == nil {
+		return store.Tokens.NewPair(ctx, user.ID, family)
+	}
+
+	permissions, err := store.Permissions.GetAllPermissionsForUser(ctx, user.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	state, err := store.Users.GetTokenState(ctx, user.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	signed, expiry, err := app.jwt.Issue(user.ID, user.Activated, state.Version, permissions)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	refresh, err := store.Tokens.NewRefresh(ctx, user.ID, family)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return &data.Token{Plaintext: signed, Expiry: expiry, UserId: user.ID, Scope: data.ScopeAuthentication}, refresh, nil
+}
+
+func (app *application) refreshAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if data.ValidateToken(v, input.RefreshToken); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	ctx := r.Context()
+
+	// the refresh token is consumed along with issuing its successor, or not at all. otherwise a
+	// failure in between would make the client's retry look like a reused token
+	var token, refreshToken *data.Token
+	var reusedBy int64
+	err = app.store.WithTx(ctx, func(tx data.Store) error {
+		reusedBy = 0
+
+		userID, family, err := tx.Tokens.Rotate(ctx, input.RefreshToken)
+		if err != nil {
+			// the family revocation has to be committed, so reuse doesn't fail the transaction. the
+			// JWTs issued in the family can't be deleted, every JWT of the user is invalidated instead
+			if errors.Is(err, data.ErrTokenReused) {
+				reusedBy = userID
+				return tx.Users.IncrementTokenVersion(ctx, userID)
+			}
+			return err
+		}
+
+		user, err := tx.Users.Get(ctx, userID)
+		if err != nil {
+			return err
+		}
+
+		token, refreshToken, err = app.newAuthenticationTokens(ctx, tx, user, family)
+		return err
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidRefreshTokenResponse(w, r)
+		case errors.Is(err, data.ErrAccountDisabled):
+			app.accountDisabledResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	if reusedBy != 0 {
+		app.forgetTokenState(reusedBy)
+		app.refreshTokenReusedResponse(w, r)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"authentication_token": token, "refresh_token": refreshToken}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) createPersonalAccessTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name        string           `json:"name"`
+		Expiry      time.Time        `json:"expiry"`
+		Permissions data.Permissions `json:"permissions"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	// otherwise a restricted token could be used to mint a broader one
+	if app.contextGetTokenScope(r) == data.ScopePersonalAccess {
+		app.personalAccessTokenNotAllowedResponse(w, r)
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusCreated, envelope{"authentication_token": token}, nil)
+	user := app.contextGetUser(r)
+
+	granted, err := app.store.Permissions.GetAllPermissionsForUser(r.Context(), user.ID)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
+
+	token := &data.Token{
+		Name:        input.Name,
+		Expiry:      input.Expiry,
+		Permissions: input.Permissions,
+	}
+
+	v := validator.New()
+	if data.ValidatePersonalAccessToken(v, token, granted); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	token, err = app.store.Tokens.NewPersonal(r.Context(), user.ID, token.Name, token.Expiry, token.Permissions)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"personal_access_token": token}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listPersonalAccessTokensHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	tokens, err := app.store.Tokens.GetAllPersonalForUser(r.Context(), user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"personal_access_tokens": tokens}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deletePersonalAccessTokenHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	if app.contextGetTokenScope(r) == data.ScopePersonalAccess {
+		app.personalAccessTokenNotAllowedResponse(w, r)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	err = app.store.Tokens.DeletePersonal(r.Context(), user.ID, id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "personal access token revoked successfully"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) jwksHandler(w http.ResponseWriter, r *http.Request) {
+	if app.jwt == nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err := app.writeJSON(w, http.StatusOK, envelope{"keys": app.jwt.JWKS()}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// sessionScopes are the token scopes revokeSessions deletes: everything that logs a user in, or is one
+// step away from it. activation tokens and recovery codes don't log anyone in on their own
+var sessionScopes = []string{
+	data.ScopeAuthentication,
+	data.ScopeRefresh,
+	data.ScopePersonalAccess,
+	data.ScopeMFAPending,
+	data.ScopeMagicLink,
+	data.ScopePasswordReset,
+}
+
+// revokeSessions deletes the tokens of a user in sessionScopes, personal access tokens included, and
+// invalidates the JWTs issued to them, logging them out everywhere. the caller has to call
+// forgetTokenState once store is committed
+func (app *application) revokeSessions(ctx context.Context, store data.Store, userID int64) error {
+	for _, scope := range sessionScopes {
+		err := store.Tokens.DeleteAllForUser(ctx, scope, userID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return store.Users.IncrementTokenVersion(ctx, userID)
+}
+
+// forgetTokenState drops the cached token state of a user, so this instance rejects the JWTs of revoked
+// sessions right away. it's called after the revocation is committed, or a request in between could
+// cache the old token version again
+func (app *application) forgetTokenState(userID int64) {
+	if app.tokenStates != nil {
+		app.tokenStates.Forget(userID)
+	}
 }
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/s-devoe/greenlight-go/internal/data"
+	"github.com/s-devoe/greenlight-go/internal/jwtauth"
+)
+
+func TestRefreshTokenReuseRevokesJWTs(t *testing.T) {
+	app := newTestApplication(t)
+	user := newTestUser(t, app, "erin@example.com")
+
+	var err error
+	app.jwt, err = jwtauth.New(jwtauth.AlgorithmHS256, []jwtauth.Key{{ID: "k1", Secret: bytes.Repeat([]byte{1}, 32)}}, "k1", "greenlight", data.AccessTokenTTL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.tokenStates = newTokenStateCache(tokenStateTTL, app.store.Users.GetTokenState)
+
+	stolen, refresh, err := app.newAuthenticationTokens(context.Background(), app.store, user, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	refreshWith := func(plaintext string) int {
+		r := httptest.NewRequest(http.MethodPost, "/v1/tokens/refresh", strings.NewReader(`{"refresh_token": "`+plaintext+`"}`))
+		rr := httptest.NewRecorder()
+		app.refreshAuthenticationTokenHandler(rr, r)
+		return rr.Code
+	}
+
+	if status := authenticateStatus(t, app, stolen.Plaintext); status != http.StatusOK {
+		t.Fatalf("JWT refused before the reuse, status %d", status)
+	}
+	if status := refreshWith(refresh.Plaintext); status != http.StatusCreated {
+		t.Fatalf("first refresh: status %d", status)
+	}
+	if status := refreshWith(refresh.Plaintext); status != http.StatusUnauthorized {
+		t.Fatalf("reused refresh token: status %d, want %d", status, http.StatusUnauthorized)
+	}
+
+	if status := authenticateStatus(t, app, stolen.Plaintext); status != http.StatusUnauthorized {
+		t.Errorf("JWT issued before the reuse: status %d, want %d", status, http.StatusUnauthorized)
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/s-devoe/greenlight-go/internal/data"
+)
+
+// tokenStateTTL is how long the token state of a user is cached for. disabling an account or
+// revoking its sessions on another instance takes up to this long to reject the user's JWTs
+const tokenStateTTL = 10 * time.Second
+
+// tokenStateCache keeps the token state of the users JWTs were recently presented for, so that
+// JWTs can be revoked without a trip to the database on every request
+type tokenStateCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	load    func(ctx context.Context, userID int64) (*data.TokenState, error)
+	entries map[int64]tokenStateEntry
+}
+
+type tokenStateEntry struct {
+	state    data.TokenState
+	loadedAt time.Time
+}
+
+func newTokenStateCache(ttl time.Duration, load func(ctx context.Context, userID int64) (*data.TokenState, error)) *tokenStateCache {
+	c := &tokenStateCache{
+		ttl:     ttl,
+		load:    load,
+		entries: make(map[int64]tokenStateEntry),
+	}
+
+	go func() {
+		for {
+			time.Sleep(time.Minute)
+			c.mu.Lock()
+
+			for userID, entry := range c.entries {
+				if time.Since(entry.loadedAt) > c.ttl {
+					delete(c.entries, userID)
+				}
+			}
+
+			c.mu.Unlock()
+		}
+	}()
+
+	return c
+}
+
+// Get returns the token state of a user, loading it when it isn't cached or is out of date
+func (c *tokenStateCache) Get(ctx context.Context, userID int64) (data.TokenState, error) {
+	c.mu.Lock()
+	entry, found := c.entries[userID]
+	c.mu.Unlock()
+
+	if found && time.Since(entry.loadedAt) <= c.ttl {
+		return entry.state, nil
+	}
+
+	state, err := c.load(ctx, userID)
+	if err != nil {
+		return data.TokenState{}, err
+	}
+
+	c.mu.Lock()
+	c.entries[userID] = tokenStateEntry{state: *state, loadedAt: time.Now()}
+	c.mu.Unlock()
+
+	return *state, nil
+}
+
+// Forget drops the cached token state of a user, so this instance sees a revocation right away
+func (c *tokenStateCache) Forget(userID int64) {
+	c.mu.Lock()
+	delete(c.entries, userID)
+	c.mu.Unlock()
+}
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/s-devoe/greenlight-go/internal/data"
+	"github.com/s-devoe/greenlight-go/internal/totp"
+	"github.com/s-devoe/greenlight-go/internal/validator"
+)
+
+// the issuer shown next to the account in authenticator apps
+const totpIssuer = "Greenlight"
+
+func (app *application) enrollTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	if app.contextGetTokenScope(r) == data.ScopePersonalAccess {
+		app.personalAccessTokenNotAllowedResponse(w, r)
+		return
+	}
+
+	ctx := r.Context()
+
+	// the user in the context may come from a JWT, which doesn't carry the email address
+	user, err := app.store.Users.Get(ctx, app.contextGetUser(r).ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	existing, err := app.store.TOTP.Get(ctx, user.ID)
+	switch {
+	case err == nil && existing.Enabled:
+		app.totpAlreadyEnabledResponse(w, r)
+		return
+	case err != nil && !errors.Is(err, data.ErrRecordNotFound):
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.store.TOTP.SetPending(ctx, user.ID, secret)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	env := envelope{
+		"secret":      secret,
+		"otpauth_uri": totp.URI(totpIssuer, user.Email, secret),
+		"message":     "add the secret to your authenticator app and post a code to /v1/users/totp/verify to enable two-factor authentication",
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) verifyTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Code string `json:"code"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if app.contextGetTokenScope(r) == data.ScopePersonalAccess {
+		app.personalAccessTokenNotAllowedResponse(w, r)
+		return
+	}
+
+	v := validator.New()
+	if data.ValidateTOTPCode(v, input.Code); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	ctx := r.Context()
+	user := app.contextGetUser(r)
+
+	setup, err := app.store.TOTP.Get(ctx, user.ID)
+	switch {
+	case errors.Is(err, data.ErrRecordNotFound):
+		app.badRequestResponse(w, r, errors.New("two-factor authentication must be enrolled first"))
+		return
+	case err != nil:
+		app.serverErrorResponse(w, r, err)
+		return
+	case setup.Enabled:
+		app.totpAlreadyEnabledResponse(w, r)
+		return
+	}
+
+	step, ok := totp.Validate(setup.Secret, input.Code, time.Now())
+	if !ok {
+		v.AddError("code", "invalid or expired code")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.store.TOTP.MarkUsed(ctx, user.ID, step)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.store.TOTP.Enable(ctx, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	codes, err := app.store.Tokens.NewRecoveryCodes(ctx, user.ID, data.RecoveryCodeCount)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	env := envelope{
+		"recovery_codes": codes,
+		"message":        "two-factor authentication enabled, keep the recovery codes somewhere safe, each one can be used once instead of a code",
+	}
+
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) disableTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Code string `json:"code"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if app.contextGetTokenScope(r) == data.ScopePersonalAccess {
+		app.personalAccessTokenNotAllowedResponse(w, r)
+		return
+	}
+
+	v := validator.New()
+	if data.ValidateTOTPCode(v, input.Code); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	ctx := r.Context()
+	user := app.contextGetUser(r)
+
+	ok, err := app.verifySecondFactor(ctx, user.ID, input.Code)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.badRequestResponse(w, r, errors.New("two-factor authentication is not enabled"))
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	if !ok {
+		v.AddError("code", "invalid or expired code")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.store.TOTP.Delete(ctx, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.store.Tokens.DeleteAllForUser(ctx, data.ScopeTOTPRecovery, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "two-factor authentication disabled"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// createMFAAuthenticationTokenHandler exchanges the "mfa pending" token returned on login,
+// along with a code or a recovery code, for the real authentication tokens
+func (app *application) createMFAAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		MFAToken string `json:"mfa_token"`
+		Code     string `json:"code"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	data.ValidateToken(v, input.MFAToken)
+	data.ValidateTOTPCode(v, input.Code)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	ctx := r.Context()
+
+	user, err := app.store.Users.GetForToken(ctx, data.ScopeMFAPending, input.MFAToken)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidAuthenticationTokenResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	wait, err := app.loginBlocked(r, user.Email)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if wait > 0 {
+		app.loginBlockedResponse(w, r, wait)
+		return
+	}
+
+	// whatever the outcome, the pending token can't be used again, so a wrong code means logging in again
+	err = app.store.Tokens.DeleteAllForUser(ctx, data.ScopeMFAPending, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	ok, err := app.verifySecondFactor(ctx, user.ID, input.Code)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !ok {
+		err = app.loginFailed(r, user.Email, user)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		app.invalidCredentialsResponse(w, r)
+		return
+	}
+
+	token, refreshToken, err := app.newAuthenticationTokens(ctx, app.store, user, "")
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrAccountDisabled):
+			app.accountDisabledResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"authentication_token": token, "refresh_token": refreshToken}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// verifySecondFactor checks a code from the user's authenticator app, or one of their recovery codes.
+// data.ErrRecordNotFound is returned if the user hasn't enabled two-factor authentication
+func (app *application) verifySecondFactor(ctx context.Context, userID int64, code string) (bool, error) {
+	setup, err := app.store.TOTP.Get(ctx, userID)
+	if err != nil {
+		return false, err
+	}
+	if !setup.Enabled {
+		return false, data.ErrRecordNotFound
+	}
+
+	if len(code) == totp.Digits {
+		step, ok := totp.Validate(setup.Secret, code, time.Now())
+		if !ok {
+			return false, nil
+		}
+
+		err = app.store.TOTP.MarkUsed(ctx, userID, step)
+		switch {
+		case errors.Is(err, data.ErrTOTPCodeReused):
+			return false, nil
+		case err != nil:
+			return false, err
+		}
+		return true, nil
+	}
+
+	err = app.store.Tokens.Consume(ctx, data.ScopeTOTPRecovery, userID, code)
+	switch {
+	case errors.Is(err, data.ErrRecordNotFound):
+		return false, nil
+	case err != nil:
+		return false, err
+	}
+	return true, nil
+}
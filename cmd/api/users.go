@@ -1,12 +1,14 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"net/http"
 	"time"
 
 	"github.com/s-devoe/greenlight-go/internal/data"
+	"github.com/s-devoe/greenlight-go/internal/tracing"
 	"github.com/s-devoe/greenlight-go/internal/validator"
 )
 
@@ -33,7 +35,9 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 		Email:     input.Email,
 		Activated: false,
 	}
+	_, span := tracing.Tracer().Start(ctx, "password.hash")
 	err = user.Password.Set(input.Password)
+	span.End()
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -45,7 +49,23 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
-	err = app.store.Users.Insert(ctx, user)
+
+	// the user is only created along with its permissions and activation token
+	var token *data.Token
+	err = app.store.WithTx(ctx, func(tx data.Store) error {
+		err := tx.Users.Insert(ctx, user)
+		if err != nil {
+			return err
+		}
+
+		err = tx.Permissions.AddPermissionsForUser(ctx, user.ID, "movies:read")
+		if err != nil {
+			return err
+		}
+
+		token, err = tx.Tokens.New(ctx, user.ID, 3*time.Minute, data.ScopeActivation)
+		return err
+	})
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrDuplicateEmail):
@@ -57,28 +77,15 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	err = app.store.Permissions.AddPermissionsForUser(user.ID, "movies:read")
-	if err != nil {
-		app.serverErrorResponse(w, r, err)
-		return
-	}
-
-	token, err := app.store.Tokens.New(ctx, user.ID, 3*time.Minute, data.ScopeActivation)
-
-	if err != nil {
-		app.serverErrorResponse(w, r, err)
-		return
-	}
-
-	app.background(func() {
+	app.background(r.Context(), "welcome email", func(ctx context.Context) {
 		data := map[string]interface{}{
 			"activationToken": token.Plaintext,
 			"userID":          user.ID,
 		}
 
-		err = app.mailer.SendMail(user.Email, "user_welcome.tmpl", data)
+		err = app.mailer.SendMail(ctx, user.Email, "user_welcome.tmpl", data)
 		if err != nil {
-			app.logger.PrintError(err, nil)
+			app.logContextError(ctx, err, nil)
 		}
 	})
 
@@ -121,15 +128,15 @@ func (app *application) resendActivationTokenHandler(w http.ResponseWriter, r *h
 		return
 	}
 
-	app.background(func() {
+	app.background(r.Context(), "welcome email", func(ctx context.Context) {
 		data := map[string]interface{}{
 			"activationToken": token.Plaintext,
 			"userID":          user.ID,
 		}
 
-		err = app.mailer.SendMail(user.Email, "user_welcome.tmpl", data)
+		err = app.mailer.SendMail(ctx, user.Email, "user_welcome.tmpl", data)
 		if err != nil {
-			app.logger.PrintError(err, nil)
+			app.logContextError(ctx, err, nil)
 		}
 	})
 	err = app.writeJSON(w, http.StatusAccepted, envelope{"message": fmt.Sprintf("token sent to your email %s", user.Email)}, nil)
@@ -155,23 +162,30 @@ func (app *application) activateUserHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	user, err := app.store.Users.GetForToken(data.ScopeActivation, input.Plaintext)
-	if err != nil {
-		switch {
-		case errors.Is(err, data.ErrRecordNotFound):
-			v.AddError("token", "invalid or expired activation token")
-			app.failedValidationResponse(w, r, v.Errors)
-		default:
-			app.serverErrorResponse(w, r, err)
+	ctx := r.Context()
+
+	// the activation tokens are deleted along with the activation, or not at all
+	var user *data.User
+	err = app.store.WithTx(ctx, func(tx data.Store) error {
+		user, err = tx.Users.GetForToken(ctx, data.ScopeActivation, input.Plaintext)
+		if err != nil {
+			return err
 		}
-		return
-	}
 
-	user.Activated = true
+		user.Activated = true
+
+		err = tx.Users.UpdateUser(ctx, user)
+		if err != nil {
+			return err
+		}
 
-	err = app.store.Users.UpdateUser(r.Context(), user)
+		return tx.Tokens.DeleteAllForUser(ctx, data.ScopeActivation, user.ID)
+	})
 	if err != nil {
 		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired activation token")
+			app.failedValidationResponse(w, r, v.Errors)
 		case errors.Is(err, data.ErrUpdateConflict):
 			app.updateConflictResponse(w, r)
 		default:
@@ -180,13 +194,6 @@ func (app *application) activateUserHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	// if everything works well, we delete all tokens associated with that user
-	err = app.store.Tokens.DeleteAllForUser(r.Context(), data.ScopeActivation, user.ID)
-	if err != nil {
-		app.serverErrorResponse(w, r, err)
-		return
-	}
-
 	err = app.writeJSON(w, http.StatusOK, envelope{"user": user}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
@@ -0,0 +1,179 @@
+// stub is a tiny OpenID Connect provider for trying out (and testing) the single sign-on login locally.
+// it logs everyone in as the same user without asking anything. run the api with:
+//
+//	OIDC_PROVIDERS=stub
+//	OIDC_STUB_ISSUER=http://localhost:9096
+//	OIDC_STUB_CLIENT_ID=greenlight
+//	OIDC_STUB_CLIENT_SECRET=secret
+//	OIDC_STUB_REDIRECT_URL=http://localhost:4000/v1/oidc/stub/callback
+//
+// and open http://localhost:4000/v1/oidc/stub/login in a browser.
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+type authorization struct {
+	clientID      string
+	redirectURI   string
+	nonce         string
+	codeChallenge string
+}
+
+func main() {
+	addr := flag.String("addr", ":9096", "Server address")
+	issuer := flag.String("issuer", "http://localhost:9096", "Issuer url, must match OIDC_<NAME>_ISSUER")
+	subject := flag.String("sub", "stub-user-1", "Subject of the logged in user")
+	email := flag.String("email", "stub.user@example.com", "Email of the logged in user")
+	name := flag.String("name", "Stub User", "Name of the logged in user")
+	emailVerified := flag.Bool("email-verified", true, "Whether the email of the logged in user is verified")
+	flag.Parse()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var (
+		mu    sync.Mutex
+		codes = make(map[string]authorization)
+	)
+
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET /.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, map[string]interface{}{
+			"issuer":                                *issuer,
+			"authorization_endpoint":                *issuer + "/authorize",
+			"token_endpoint":                        *issuer + "/token",
+			"jwks_uri":                              *issuer + "/jwks",
+			"response_types_supported":              []string{"code"},
+			"subject_types_supported":               []string{"public"},
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+			"code_challenge_methods_supported":      []string{"S256"},
+		})
+	})
+
+	mux.HandleFunc("GET /jwks", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": "stub",
+				"use": "sig",
+				"alg": "RS256",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+
+	mux.HandleFunc("GET /authorize", func(w http.ResponseWriter, r *http.Request) {
+		qs := r.URL.Query()
+		if qs.Get("code_challenge_method") != "S256" {
+			http.Error(w, "code_challenge_method must be S256", http.StatusBadRequest)
+			return
+		}
+
+		code := randomString()
+
+		mu.Lock()
+		codes[code] = authorization{
+			clientID:      qs.Get("client_id"),
+			redirectURI:   qs.Get("redirect_uri"),
+			nonce:         qs.Get("nonce"),
+			codeChallenge: qs.Get("code_challenge"),
+		}
+		mu.Unlock()
+
+		redirect, err := url.Parse(qs.Get("redirect_uri"))
+		if err != nil {
+			http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+			return
+		}
+		values := redirect.Query()
+		values.Set("code", code)
+		values.Set("state", qs.Get("state"))
+		redirect.RawQuery = values.Encode()
+
+		http.Redirect(w, r, redirect.String(), http.StatusFound)
+	})
+
+	mux.HandleFunc("POST /token", func(w http.ResponseWriter, r *http.Request) {
+		code := r.PostFormValue("code")
+
+		mu.Lock()
+		auth, found := codes[code]
+		delete(codes, code)
+		mu.Unlock()
+
+		clientID, _, ok := r.BasicAuth()
+		if !ok {
+			clientID = r.PostFormValue("client_id")
+		}
+
+		challenge := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+		switch {
+		case !found, auth.clientID != clientID, auth.redirectURI != r.PostFormValue("redirect_uri"):
+			http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+			return
+		case base64.RawURLEncoding.EncodeToString(challenge[:]) != auth.codeChallenge:
+			http.Error(w, `{"error":"invalid_grant","error_description":"pkce verification failed"}`, http.StatusBadRequest)
+			return
+		}
+
+		now := time.Now()
+		idToken := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+			"iss":            *issuer,
+			"sub":            *subject,
+			"aud":            clientID,
+			"iat":            now.Unix(),
+			"exp":            now.Add(5 * time.Minute).Unix(),
+			"nonce":          auth.nonce,
+			"email":          *email,
+			"email_verified": *emailVerified,
+			"name":           *name,
+		})
+		idToken.Header["kid"] = "stub"
+
+		signed, err := idToken.SignedString(key)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		writeJSON(w, map[string]interface{}{
+			"access_token": randomString(),
+			"token_type":   "Bearer",
+			"expires_in":   300,
+			"id_token":     signed,
+		})
+	})
+
+	log.Printf("starting stub oidc provider on %s", *addr)
+	log.Fatal(http.ListenAndServe(*addr, mux))
+}
+
+func writeJSON(w http.ResponseWriter, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(data)
+}
+
+func randomString() string {
+	randomBytes := make([]byte, 16)
+	rand.Read(randomBytes)
+	return base64.RawURLEncoding.EncodeToString(randomBytes)
+}
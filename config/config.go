@@ -0,0 +1,187 @@
+// Package config loads the API configuration. Every setting starts from its default and is then
+// overridden, in this order, by the config file, the environment and the command-line flags, so a
+// flag always wins. The file is YAML, with the keys given by the yaml tags below. The environment
+// variables are named by the env tags, and the flags are the yaml keys with dashes, e.g. -db-source.
+// Settings tagged secret can also be read from the file named by the <ENV>_FILE variable.
+package config
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+	"time"
+)
+
+type Config struct {
+	// Database Configuration
+	DbSource string `yaml:"db_source" env:"DB_SOURCE" secret:"true"`
+
+	// Read replicas, optional. listings and movie lookups are spread over the replicas, each checked
+	// every DBReplicaCheckInterval and left out while it's unreachable or more than DBReplicaMaxLag behind.
+	// in the environment the DSNs are separated by commas
+	DBReplicaSources       []string      `yaml:"db_replica_sources" env:"DB_REPLICA_SOURCES" secret:"true"`
+	DBReplicaMaxLag        time.Duration `yaml:"db_replica_max_lag" env:"DB_REPLICA_MAX_LAG"`
+	DBReplicaCheckInterval time.Duration `yaml:"db_replica_check_interval" env:"DB_REPLICA_CHECK_INTERVAL"`
+
+	// Connection pool
+	DBMaxConns          int           `yaml:"db_max_conns" env:"DB_MAX_CONNS"`
+	DBMinConns          int           `yaml:"db_min_conns" env:"DB_MIN_CONNS"`
+	DBMaxConnLifetime   time.Duration `yaml:"db_max_conn_lifetime" env:"DB_MAX_CONN_LIFETIME"`
+	DBMaxConnIdleTime   time.Duration `yaml:"db_max_conn_idle_time" env:"DB_MAX_CONN_IDLE_TIME"`
+	DBHealthCheckPeriod time.Duration `yaml:"db_health_check_period" env:"DB_HEALTH_CHECK_PERIOD"`
+	DBConnectTimeout    time.Duration `yaml:"db_connect_timeout" env:"DB_CONNECT_TIMEOUT"`
+
+	// Query timeouts by kind of query: lookups by key, inserts/updates/deletes, searches and
+	// listings, search-as-you-type suggestions and background purges. queries taking longer
+	// than DBSlowQueryThreshold are logged (0 turns it off)
+	DBReadTimeout        time.Duration `yaml:"db_read_timeout" env:"DB_READ_TIMEOUT"`
+	DBWriteTimeout       time.Duration `yaml:"db_write_timeout" env:"DB_WRITE_TIMEOUT"`
+	DBListTimeout        time.Duration `yaml:"db_list_timeout" env:"DB_LIST_TIMEOUT"`
+	DBSuggestTimeout     time.Duration `yaml:"db_suggest_timeout" env:"DB_SUGGEST_TIMEOUT"`
+	DBMaintenanceTimeout time.Duration `yaml:"db_maintenance_timeout" env:"DB_MAINTENANCE_TIMEOUT"`
+	DBSlowQueryThreshold time.Duration `yaml:"db_slow_query_threshold" env:"DB_SLOW_QUERY_THRESHOLD"`
+
+	Port int `yaml:"port" env:"PORT"`
+	// AdminPort serves pprof, expvar, metrics and runtime settings on localhost only, 0 turns it off
+	AdminPort int    `yaml:"admin_port" env:"ADMIN_PORT"`
+	Env       string `yaml:"env" env:"ENV"`
+
+	// Logging, LogLevel is DEBUG, INFO, WARN, ERROR, FATAL or OFF. within every second, only the first
+	// LogSampleFirst entries with the same message are logged and then one in every LogSampleThereafter
+	// (0 turns sampling off), errors are never sampled
+	LogLevel            string `yaml:"log_level" env:"LOG_LEVEL"`
+	LogSampleFirst      int    `yaml:"log_sample_first" env:"LOG_SAMPLE_FIRST"`
+	LogSampleThereafter int    `yaml:"log_sample_thereafter" env:"LOG_SAMPLE_THEREAFTER"`
+
+	// Rate Limiting Configuration
+	LimiterRPS     int  `yaml:"limiter_rps" env:"LIMITER_RPS"`
+	LimiterBurst   int  `yaml:"limiter_burst" env:"LIMITER_BURST"`
+	LimiterEnabled bool `yaml:"limiter_enabled" env:"LIMITER_ENABLED"`
+
+	// TrustedProxies are the addresses or CIDR ranges of the proxies and load balancers in front of the
+	// API. the requests they relay are attributed to the client they name in X-Forwarded-For, for rate
+	// limiting, login throttling and logging. in the environment they're separated by commas
+	TrustedProxies []string `yaml:"trusted_proxies" env:"TRUSTED_PROXIES"`
+
+	// SMTP Settings
+	SMTPHost     string `yaml:"smtp_host" env:"SMTP_HOST"`
+	SMTPPort     int    `yaml:"smtp_port" env:"SMTP_PORT"`
+	SMTPUsername string `yaml:"smtp_username" env:"SMTP_USERNAME"`
+	SMTPPassword string `yaml:"smtp_password" env:"SMTP_PASSWORD" secret:"true"`
+	SMTPSender   string `yaml:"smtp_sender" env:"SMTP_SENDER"`
+
+	// Blob Storage
+	BlobRoot string `yaml:"blob_root" env:"BLOB_ROOT"`
+
+	// Authentication, AuthMode is either "opaque" (tokens stored in the database) or "jwt"
+	AuthMode       string `yaml:"auth_mode" env:"AUTH_MODE"`
+	JWTAlgorithm   string `yaml:"jwt_algorithm" env:"JWT_ALGORITHM"`
+	JWTKeys        string `yaml:"jwt_keys" env:"JWT_KEYS" secret:"true"`
+	JWTActiveKeyID string `yaml:"jwt_active_key_id" env:"JWT_ACTIVE_KEY_ID"`
+	JWTIssuer      string `yaml:"jwt_issuer" env:"JWT_ISSUER"`
+
+	// Password hashing, argon2id cost parameters used for new hashes (memory in KiB)
+	Argon2Memory      int `yaml:"argon2_memory" env:"ARGON2_MEMORY"`
+	Argon2Iterations  int `yaml:"argon2_iterations" env:"ARGON2_ITERATIONS"`
+	Argon2Parallelism int `yaml:"argon2_parallelism" env:"ARGON2_PARALLELISM"`
+
+	// PwnedPasswordsPath optionally points at a local copy of the Have I Been Pwned password hashes,
+	// either a directory of range files or a single file ordered by hash
+	PwnedPasswordsPath string `yaml:"pwned_passwords_path" env:"PWNED_PASSWORDS_PATH"`
+
+	// Tracing, TracingExporter is "none", "stdout" or "otlp" (sent to TracingEndpoint, or where the
+	// standard OTEL_EXPORTER_OTLP_* variables say when it's empty)
+	TracingExporter    string  `yaml:"tracing_exporter" env:"TRACING_EXPORTER"`
+	TracingEndpoint    string  `yaml:"tracing_endpoint" env:"TRACING_ENDPOINT"`
+	TracingSampleRatio float64 `yaml:"tracing_sample_ratio" env:"TRACING_SAMPLE_RATIO"`
+
+	// OpenID Connect identity providers. in the environment they're listed by name in OIDC_PROVIDERS
+	// and configured with OIDC_<NAME>_ISSUER, OIDC_<NAME>_CLIENT_ID, OIDC_<NAME>_CLIENT_SECRET and
+	// OIDC_<NAME>_REDIRECT_URL, replacing the providers of the config file
+	OIDCProviders []OIDCProvider `yaml:"oidc_providers"`
+
+	// ShutdownDrainDelay is how long the server keeps serving after failing its readiness check
+	// on shutdown, giving load balancers time to notice and stop sending requests
+	ShutdownDrainDelay time.Duration `yaml:"shutdown_drain_delay" env:"SHUTDOWN_DRAIN_DELAY"`
+}
+
+type OIDCProvider struct {
+	Name         string `yaml:"name"`
+	Issuer       string `yaml:"issuer"`
+	ClientID     string `yaml:"client_id"`
+	ClientSecret string `yaml:"client_secret"`
+	RedirectURL  string `yaml:"redirect_url"`
+}
+
+// Default is the configuration used for anything left unset. there's deliberately no default
+// database DSN, it has to be given
+func Default() Config {
+	return Config{
+		DBMaxConns:          4,
+		DBMinConns:          0,
+		DBMaxConnLifetime:   time.Hour,
+		DBMaxConnIdleTime:   30 * time.Minute,
+		DBHealthCheckPeriod: time.Minute,
+		DBConnectTimeout:    5 * time.Second,
+
+		DBReadTimeout:        3 * time.Second,
+		DBWriteTimeout:       5 * time.Second,
+		DBListTimeout:        10 * time.Second,
+		DBSuggestTimeout:     300 * time.Millisecond,
+		DBMaintenanceTimeout: 30 * time.Second,
+		DBSlowQueryThreshold: 500 * time.Millisecond,
+
+		DBReplicaMaxLag:        5 * time.Second,
+		DBReplicaCheckInterval: 5 * time.Second,
+
+		Port:      4000,
+		AdminPort: 4001,
+		Env:       "development",
+
+		LogLevel:            "INFO",
+		LogSampleFirst:      0,
+		LogSampleThereafter: 100,
+
+		LimiterRPS:     2,
+		LimiterBurst:   4,
+		LimiterEnabled: true,
+		SMTPHost:       "smtp.mailtrap.io",
+		SMTPPort:       2525,
+		BlobRoot:       "./uploads",
+
+		AuthMode:     "opaque",
+		JWTAlgorithm: "HS256",
+		JWTIssuer:    "greenlight",
+
+		Argon2Memory:      64 * 1024,
+		Argon2Iterations:  3,
+		Argon2Parallelism: 2,
+
+		TracingExporter:    "none",
+		TracingSampleRatio: 1,
+
+		ShutdownDrainDelay: 5 * time.Second,
+	}
+}
+
+// TrustedProxyPrefixes parses TrustedProxies, a single address being a range of its own
+func (c Config) TrustedProxyPrefixes() ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(c.TrustedProxies))
+	for _, proxy := range c.TrustedProxies {
+		if strings.Contains(proxy, "/") {
+			prefix, err := netip.ParsePrefix(proxy)
+			if err != nil {
+				return nil, fmt.Errorf("trusted proxy %q: %w", proxy, err)
+			}
+			prefixes = append(prefixes, prefix.Masked())
+			continue
+		}
+
+		addr, err := netip.ParseAddr(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("trusted proxy %q: %w", proxy, err)
+		}
+		prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+	}
+	return prefixes, nil
+}
@@ -0,0 +1,250 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/joho/godotenv"
+	"gopkg.in/yaml.v3"
+)
+
+// setting is a Config field that can be set from the environment and the command line
+type setting struct {
+	index  int
+	key    string
+	env    string
+	flag   string
+	secret bool
+}
+
+func settings() []setting {
+	var list []setting
+
+	t := reflect.TypeOf(Config{})
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		env, ok := field.Tag.Lookup("env")
+		if !ok {
+			continue
+		}
+
+		key := field.Tag.Get("yaml")
+		list = append(list, setting{
+			index:  i,
+			key:    key,
+			env:    env,
+			flag:   strings.ReplaceAll(key, "_", "-"),
+			secret: field.Tag.Get("secret") == "true",
+		})
+	}
+
+	return list
+}
+
+// Load builds the configuration from the command-line arguments (without the program name),
+// the environment, the config file and the defaults, then validates it. printConfig reports
+// whether -print-config was given. when the configuration is invalid, the error comes with as much
+// of the configuration as could be built, so it can still be printed.
+func Load(args []string) (cfg Config, printConfig bool, err error) {
+	// a .env file is only a convenience for development, it's fine for it to be missing
+	godotenv.Load()
+
+	fs := flag.NewFlagSet("greenlight", flag.ContinueOnError)
+	path := fs.String("config", os.Getenv("CONFIG_FILE"), "path of the YAML config file (CONFIG_FILE)")
+	fs.BoolVar(&printConfig, "print-config", false, "print the configuration with the secrets redacted and exit")
+
+	// the flags are applied after the file and the environment, so they're only collected here
+	settings := settings()
+	flags := make(map[string]string)
+	for _, s := range settings {
+		collect := func(value string) error {
+			flags[s.flag] = value
+			return nil
+		}
+
+		usage := "overrides " + s.env
+		if reflect.TypeOf(cfg).Field(s.index).Type.Kind() == reflect.Bool {
+			fs.BoolFunc(s.flag, usage, collect)
+		} else {
+			fs.Func(s.flag, usage, collect)
+		}
+	}
+
+	err = fs.Parse(args)
+	if err != nil {
+		return Default(), printConfig, err
+	}
+
+	cfg = Default()
+
+	var errs []string
+
+	if *path != "" {
+		err = loadFile(&cfg, *path)
+		if err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+
+	fields := reflect.ValueOf(&cfg).Elem()
+
+	for _, s := range settings {
+		value, ok, err := lookupEnv(s.env, s.secret)
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		if ok {
+			err = setField(fields.Field(s.index), value)
+			if err != nil {
+				errs = append(errs, fmt.Sprintf("%s: %v", s.env, err))
+			}
+		}
+	}
+
+	if names, ok := os.LookupEnv("OIDC_PROVIDERS"); ok {
+		cfg.OIDCProviders, err = oidcProvidersFromEnv(names)
+		if err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+
+	for _, s := range settings {
+		if value, ok := flags[s.flag]; ok {
+			err = setField(fields.Field(s.index), value)
+			if err != nil {
+				errs = append(errs, fmt.Sprintf("-%s: %v", s.flag, err))
+			}
+		}
+	}
+
+	if len(errs) > 0 {
+		return cfg, printConfig, fmt.Errorf("invalid configuration: %s", strings.Join(errs, "; "))
+	}
+
+	return cfg, printConfig, cfg.Validate()
+}
+
+// loadFile decodes the YAML file over cfg, so the settings it leaves out keep their value.
+// unknown keys are rejected, a typo shouldn't silently leave a default in place
+func loadFile(cfg *Config, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	dec := yaml.NewDecoder(f)
+	dec.KnownFields(true)
+
+	err = dec.Decode(cfg)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("config file %s: %w", path, err)
+	}
+
+	return nil
+}
+
+// lookupEnv reads an environment variable, or for secrets the content of the file named by
+// the variable with a _FILE suffix, which is how container orchestrators hand secrets over
+func lookupEnv(key string, secret bool) (string, bool, error) {
+	if secret {
+		if path, ok := os.LookupEnv(key + "_FILE"); ok {
+			if _, ok := os.LookupEnv(key); ok {
+				return "", false, fmt.Errorf("%s and %s_FILE are both set", key, key)
+			}
+
+			content, err := os.ReadFile(path)
+			if err != nil {
+				return "", false, fmt.Errorf("%s_FILE: %w", key, err)
+			}
+			return strings.TrimRight(string(content), "\r\n"), true, nil
+		}
+	}
+
+	value, ok := os.LookupEnv(key)
+	return value, ok, nil
+}
+
+func setField(field reflect.Value, value string) error {
+	if field.Type() == reflect.TypeOf(time.Duration(0)) {
+		duration, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("invalid duration %q", value)
+		}
+		field.SetInt(int64(duration))
+		return nil
+	}
+
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Int:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", value)
+		}
+		field.SetInt(int64(n))
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q", value)
+		}
+		field.SetBool(b)
+	case reflect.Slice:
+		if field.Type().Elem().Kind() != reflect.String {
+			return fmt.Errorf("unsupported setting type %s", field.Type())
+		}
+		var list []string
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+		field.Set(reflect.ValueOf(list))
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("invalid number %q", value)
+		}
+		field.SetFloat(f)
+	default:
+		return fmt.Errorf("unsupported setting type %s", field.Type())
+	}
+
+	return nil
+}
+
+func oidcProvidersFromEnv(names string) ([]OIDCProvider, error) {
+	var providers []OIDCProvider
+
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+
+		prefix := "OIDC_" + strings.ToUpper(name) + "_"
+		secret, _, err := lookupEnv(prefix+"CLIENT_SECRET", true)
+		if err != nil {
+			return nil, err
+		}
+
+		providers = append(providers, OIDCProvider{
+			Name:         name,
+			Issuer:       os.Getenv(prefix + "ISSUER"),
+			ClientID:     os.Getenv(prefix + "CLIENT_ID"),
+			ClientSecret: secret,
+			RedirectURL:  os.Getenv(prefix + "REDIRECT_URL"),
+		})
+	}
+
+	return providers, nil
+}
@@ -0,0 +1,254 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// clearEnv unsets every variable Load reads, for the duration of the test
+func clearEnv(t *testing.T) {
+	t.Helper()
+
+	unset := func(key string) {
+		t.Setenv(key, "")
+		os.Unsetenv(key)
+	}
+
+	for _, s := range settings() {
+		unset(s.env)
+		if s.secret {
+			unset(s.env + "_FILE")
+		}
+	}
+	unset("CONFIG_FILE")
+	unset("OIDC_PROVIDERS")
+}
+
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), name)
+	err := os.WriteFile(path, []byte(content), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	clearEnv(t)
+
+	path := writeFile(t, "config.yaml", `
+db_source: postgres://file
+port: 5000
+db_max_conns: 10
+env: staging
+`)
+
+	t.Setenv("PORT", "6000")
+	t.Setenv("ENV", "production")
+	t.Setenv("DB_REPLICA_SOURCES", "postgres://a, postgres://b,")
+
+	cfg, printConfig, err := Load([]string{"-config", path, "-port", "7000", "-limiter-enabled=false"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if printConfig {
+		t.Error("printConfig set without -print-config")
+	}
+
+	tests := []struct {
+		name string
+		got  interface{}
+		want interface{}
+	}{
+		{"flag over env and file", cfg.Port, 7000},
+		{"env over file", cfg.Env, "production"},
+		{"file over default", cfg.DBMaxConns, 10},
+		{"file only", cfg.DbSource, "postgres://file"},
+		{"default", cfg.DBReadTimeout, 3 * time.Second},
+		{"boolean flag", cfg.LimiterEnabled, false},
+		{"list from env", cfg.DBReplicaSources, []string{"postgres://a", "postgres://b"}},
+	}
+
+	for _, tt := range tests {
+		if !reflect.DeepEqual(tt.got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, tt.got, tt.want)
+		}
+	}
+}
+
+func TestLoadConfigFileFromEnv(t *testing.T) {
+	clearEnv(t)
+
+	t.Setenv("CONFIG_FILE", writeFile(t, "config.yaml", "db_source: postgres://file\nport: 5000\n"))
+
+	cfg, _, err := Load(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Port != 5000 {
+		t.Errorf("port = %d, want 5000 from CONFIG_FILE", cfg.Port)
+	}
+}
+
+func TestLoadSecretFiles(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		want    string
+		wantErr string
+	}{
+		{
+			name: "value from the file",
+			env:  map[string]string{"DB_SOURCE_FILE": writeFile(t, "db_source", "postgres://secret\n")},
+			want: "postgres://secret",
+		},
+		{
+			name: "plain variable",
+			env:  map[string]string{"DB_SOURCE": "postgres://plain"},
+			want: "postgres://plain",
+		},
+		{
+			name:    "both set",
+			env:     map[string]string{"DB_SOURCE": "postgres://plain", "DB_SOURCE_FILE": writeFile(t, "db_source", "postgres://secret")},
+			wantErr: "DB_SOURCE and DB_SOURCE_FILE are both set",
+		},
+		{
+			name:    "missing file",
+			env:     map[string]string{"DB_SOURCE_FILE": filepath.Join(t.TempDir(), "missing")},
+			wantErr: "DB_SOURCE_FILE",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clearEnv(t)
+			for key, value := range tt.env {
+				t.Setenv(key, value)
+			}
+
+			cfg, _, err := Load(nil)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Load() error = %v, want it to mention %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cfg.DbSource != tt.want {
+				t.Errorf("db_source = %q, want %q", cfg.DbSource, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadNonSecretIgnoresFile(t *testing.T) {
+	clearEnv(t)
+	t.Setenv("DB_SOURCE", "postgres://plain")
+	t.Setenv("PORT_FILE", writeFile(t, "port", "5000"))
+
+	cfg, _, err := Load(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Port != 4000 {
+		t.Errorf("port = %d, PORT_FILE must only be read for secrets", cfg.Port)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		file    string
+		args    []string
+		wantErr string
+	}{
+		{name: "invalid integer", env: map[string]string{"PORT": "abc"}, wantErr: `PORT: invalid integer "abc"`},
+		{name: "invalid duration flag", args: []string{"-db-read-timeout", "3"}, wantErr: `-db-read-timeout: invalid duration "3"`},
+		{name: "invalid boolean", env: map[string]string{"LIMITER_ENABLED": "maybe"}, wantErr: `invalid boolean "maybe"`},
+		{name: "unknown file key", file: "db_source: postgres://file\nprot: 5000\n", wantErr: "prot"},
+		{name: "invalid setting", args: []string{"-port", "70000"}, wantErr: "'port':must be between 1 and 65535"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clearEnv(t)
+			t.Setenv("DB_SOURCE", "postgres://plain")
+			for key, value := range tt.env {
+				t.Setenv(key, value)
+			}
+
+			args := tt.args
+			if tt.file != "" {
+				args = append([]string{"-config", writeFile(t, "config.yaml", tt.file)}, args...)
+			}
+
+			_, _, err := Load(args)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Load() error = %v, want it to mention %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLoadReturnsPartialConfig(t *testing.T) {
+	clearEnv(t)
+	t.Setenv("DB_SOURCE", "postgres://plain")
+	t.Setenv("DB_MAX_CONNS", "many")
+
+	cfg, printConfig, err := Load([]string{"-print-config", "-port", "7000"})
+	if err == nil {
+		t.Fatal("Load() accepted an invalid integer")
+	}
+	if !printConfig {
+		t.Error("printConfig not reported along with the error")
+	}
+	if cfg.Port != 7000 || cfg.DbSource != "postgres://plain" || cfg.DBReadTimeout != 3*time.Second {
+		t.Errorf("the configuration built before the error was lost: %+v", cfg)
+	}
+}
+
+func TestLoadHelp(t *testing.T) {
+	clearEnv(t)
+
+	_, _, err := Load([]string{"-h"})
+	if !errors.Is(err, flag.ErrHelp) {
+		t.Errorf("Load(-h) error = %v, want flag.ErrHelp", err)
+	}
+}
+
+func TestLoadOIDCProvidersFromEnv(t *testing.T) {
+	clearEnv(t)
+	t.Setenv("DB_SOURCE", "postgres://plain")
+	t.Setenv("OIDC_PROVIDERS", "google")
+	t.Setenv("OIDC_GOOGLE_ISSUER", "https://accounts.google.com")
+	t.Setenv("OIDC_GOOGLE_CLIENT_ID", "client")
+	t.Setenv("OIDC_GOOGLE_CLIENT_SECRET_FILE", writeFile(t, "secret", "s3cret\n"))
+	t.Setenv("OIDC_GOOGLE_REDIRECT_URL", "http://localhost:4000/v1/oidc/google/callback")
+
+	cfg, _, err := Load(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []OIDCProvider{{
+		Name:         "google",
+		Issuer:       "https://accounts.google.com",
+		ClientID:     "client",
+		ClientSecret: "s3cret",
+		RedirectURL:  "http://localhost:4000/v1/oidc/google/callback",
+	}}
+	if !reflect.DeepEqual(cfg.OIDCProviders, want) {
+		t.Errorf("oidc providers = %+v, want %+v", cfg.OIDCProviders, want)
+	}
+}
@@ -0,0 +1,75 @@
+package config
+
+import (
+	"io"
+	"net/url"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const redacted = "[REDACTED]"
+
+// Redacted returns a copy of the config that is safe to print or serve, with passwords,
+// keys and client secrets replaced.
+func (c Config) Redacted() Config {
+	c.DbSource = redactDSN(c.DbSource)
+
+	replicas := make([]string, len(c.DBReplicaSources))
+	for i, dsn := range c.DBReplicaSources {
+		replicas[i] = redactDSN(dsn)
+	}
+	c.DBReplicaSources = replicas
+	c.SMTPPassword = redactSecret(c.SMTPPassword)
+	c.JWTKeys = redactSecret(c.JWTKeys)
+
+	providers := make([]OIDCProvider, len(c.OIDCProviders))
+	for i, provider := range c.OIDCProviders {
+		provider.ClientSecret = redactSecret(provider.ClientSecret)
+		providers[i] = provider
+	}
+	c.OIDCProviders = providers
+
+	return c
+}
+
+// redactSecret keeps empty values empty, it's useful to see a secret wasn't set
+func redactSecret(s string) string {
+	if s == "" {
+		return ""
+	}
+	return redacted
+}
+
+// redactDSN hides the password of a postgres:// URL but keeps the rest, the key=value
+// form is hidden entirely if it holds a password
+func redactDSN(dsn string) string {
+	u, err := url.Parse(dsn)
+	if err == nil && u.Scheme != "" {
+		// Redacted only covers the user info, pgx also takes the password as a parameter
+		if u.Query().Has("password") {
+			query := u.Query()
+			query.Set("password", "xxxxx")
+			u.RawQuery = query.Encode()
+		}
+		return u.Redacted()
+	}
+
+	if strings.Contains(dsn, "password") {
+		return redacted
+	}
+	return dsn
+}
+
+// Print writes the redacted configuration as YAML, in the format of the config file
+func (c Config) Print(w io.Writer) error {
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+
+	err := enc.Encode(c.Redacted())
+	if err != nil {
+		return err
+	}
+
+	return enc.Close()
+}
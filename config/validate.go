@@ -0,0 +1,87 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/s-devoe/greenlight-go/internal/jsonlog"
+	"github.com/s-devoe/greenlight-go/internal/validator"
+)
+
+// Validate reports every invalid setting at once, keyed like the config file
+func (c Config) Validate() error {
+	v := validator.New()
+
+	v.Check(c.DbSource != "", "db_source", "must be provided")
+	v.Check(c.DBMaxConns >= 1, "db_max_conns", "must be at least 1")
+	v.Check(c.DBMinConns >= 0 && c.DBMinConns <= c.DBMaxConns, "db_min_conns", "must be between 0 and db_max_conns")
+	v.Check(c.DBMaxConnLifetime > 0, "db_max_conn_lifetime", "must be greater than zero")
+	v.Check(c.DBMaxConnIdleTime > 0, "db_max_conn_idle_time", "must be greater than zero")
+	v.Check(c.DBHealthCheckPeriod > 0, "db_health_check_period", "must be greater than zero")
+	v.Check(c.DBConnectTimeout > 0, "db_connect_timeout", "must be greater than zero")
+	v.Check(c.DBReadTimeout > 0, "db_read_timeout", "must be greater than zero")
+	v.Check(c.DBWriteTimeout > 0, "db_write_timeout", "must be greater than zero")
+	v.Check(c.DBListTimeout > 0, "db_list_timeout", "must be greater than zero")
+	v.Check(c.DBSuggestTimeout > 0, "db_suggest_timeout", "must be greater than zero")
+	v.Check(c.DBMaintenanceTimeout > 0, "db_maintenance_timeout", "must be greater than zero")
+	v.Check(c.DBSlowQueryThreshold >= 0, "db_slow_query_threshold", "must not be negative")
+
+	if len(c.DBReplicaSources) > 0 {
+		v.Check(c.DBReplicaMaxLag > 0, "db_replica_max_lag", "must be greater than zero")
+		v.Check(c.DBReplicaCheckInterval > 0, "db_replica_check_interval", "must be greater than zero")
+	}
+
+	v.Check(c.Port > 0 && c.Port <= 65535, "port", "must be between 1 and 65535")
+	v.Check(c.AdminPort >= 0 && c.AdminPort <= 65535, "admin_port", "must be between 0 and 65535")
+	v.Check(c.AdminPort != c.Port, "admin_port", "must be different from port")
+	v.Check(validator.In(c.Env, "development", "staging", "production"), "env", "must be development, staging or production")
+
+	_, err := jsonlog.ParseLevel(c.LogLevel)
+	v.Check(err == nil, "log_level", "must be DEBUG, INFO, WARN, ERROR, FATAL or OFF")
+	v.Check(c.LogSampleFirst >= 0, "log_sample_first", "must not be negative")
+	v.Check(c.LogSampleThereafter >= 0, "log_sample_thereafter", "must not be negative")
+
+	if c.LimiterEnabled {
+		v.Check(c.LimiterRPS > 0, "limiter_rps", "must be greater than zero")
+		v.Check(c.LimiterBurst > 0, "limiter_burst", "must be greater than zero")
+	}
+
+	_, err = c.TrustedProxyPrefixes()
+	v.Check(err == nil, "trusted_proxies", "must be IP addresses or CIDR ranges")
+
+	v.Check(c.SMTPHost != "", "smtp_host", "must be provided")
+	v.Check(c.SMTPPort > 0 && c.SMTPPort <= 65535, "smtp_port", "must be between 1 and 65535")
+	v.Check(c.BlobRoot != "", "blob_root", "must be provided")
+
+	v.Check(validator.In(c.AuthMode, "opaque", "jwt"), "auth_mode", "must be opaque or jwt")
+	if c.AuthMode == "jwt" {
+		v.Check(validator.In(c.JWTAlgorithm, "HS256", "EdDSA"), "jwt_algorithm", "must be HS256 or EdDSA")
+		v.Check(c.JWTKeys != "", "jwt_keys", "must be provided when auth_mode is jwt")
+	}
+
+	v.Check(c.Argon2Iterations >= 1, "argon2_iterations", "must be at least 1")
+	v.Check(c.Argon2Parallelism >= 1 && c.Argon2Parallelism <= 255, "argon2_parallelism", "must be between 1 and 255")
+	v.Check(c.Argon2Memory >= 8*c.Argon2Parallelism, "argon2_memory", "must be at least 8 KiB per thread")
+
+	v.Check(validator.In(c.TracingExporter, "none", "stdout", "otlp"), "tracing_exporter", "must be none, stdout or otlp")
+	v.Check(c.TracingSampleRatio >= 0 && c.TracingSampleRatio <= 1, "tracing_sample_ratio", "must be between 0 and 1")
+
+	names := make([]string, 0, len(c.OIDCProviders))
+	for _, provider := range c.OIDCProviders {
+		key := "oidc_providers." + provider.Name
+		v.Check(provider.Name != "", "oidc_providers", "every provider must have a name")
+		v.Check(provider.Issuer != "", key, "issuer must be provided")
+		v.Check(provider.ClientID != "", key, "client_id must be provided")
+		v.Check(provider.RedirectURL != "", key, "redirect_url must be provided")
+		names = append(names, provider.Name)
+	}
+	v.Check(validator.Unique(names), "oidc_providers", "names must be unique")
+
+	v.Check(c.ShutdownDrainDelay >= 0, "shutdown_drain_delay", "must not be negative")
+
+	if !v.Valid() {
+		return fmt.Errorf("invalid configuration: %s", strings.Join(v.Errors, "; "))
+	}
+
+	return nil
+}
@@ -0,0 +1,77 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func validConfig() Config {
+	cfg := Default()
+	cfg.DbSource = "postgres://greenlight@localhost/greenlight"
+	return cfg
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		modify  func(c *Config)
+		wantErr string
+	}{
+		{name: "defaults with a database", modify: func(c *Config) {}},
+		{name: "missing database", modify: func(c *Config) { c.DbSource = "" }, wantErr: "'db_source':must be provided"},
+		{name: "min conns over max", modify: func(c *Config) { c.DBMinConns = 5 }, wantErr: "db_min_conns"},
+		{name: "zero read timeout", modify: func(c *Config) { c.DBReadTimeout = 0 }, wantErr: "db_read_timeout"},
+		{name: "replicas without lag", modify: func(c *Config) {
+			c.DBReplicaSources = []string{"postgres://replica"}
+			c.DBReplicaMaxLag = 0
+		}, wantErr: "db_replica_max_lag"},
+		{name: "no replicas, no lag", modify: func(c *Config) { c.DBReplicaMaxLag = 0 }},
+		{name: "admin port on the public port", modify: func(c *Config) { c.AdminPort = c.Port }, wantErr: "'admin_port':must be different from port"},
+		{name: "admin port off", modify: func(c *Config) { c.AdminPort = 0 }},
+		{name: "unknown env", modify: func(c *Config) { c.Env = "test" }, wantErr: "'env':"},
+		{name: "unknown log level", modify: func(c *Config) { c.LogLevel = "LOUD" }, wantErr: "log_level"},
+		{name: "limiter off ignores its rate", modify: func(c *Config) {
+			c.LimiterEnabled = false
+			c.LimiterRPS = 0
+		}},
+		{name: "trusted proxies", modify: func(c *Config) { c.TrustedProxies = []string{"10.0.0.0/8", "192.168.1.10", "::1"} }},
+		{name: "invalid trusted proxy", modify: func(c *Config) { c.TrustedProxies = []string{"10.0.0.0/33"} }, wantErr: "trusted_proxies"},
+		{name: "jwt without keys", modify: func(c *Config) { c.AuthMode = "jwt" }, wantErr: "jwt_keys"},
+		{name: "unknown auth mode", modify: func(c *Config) { c.AuthMode = "basic" }, wantErr: "auth_mode"},
+		{name: "argon2 memory too low", modify: func(c *Config) { c.Argon2Memory = 8 }, wantErr: "argon2_memory"},
+		{name: "sample ratio over 1", modify: func(c *Config) { c.TracingSampleRatio = 1.5 }, wantErr: "tracing_sample_ratio"},
+		{name: "incomplete oidc provider", modify: func(c *Config) {
+			c.OIDCProviders = []OIDCProvider{{Name: "google"}}
+		}, wantErr: "'oidc_providers.google':issuer must be provided"},
+		{name: "duplicate oidc providers", modify: func(c *Config) {
+			provider := OIDCProvider{Name: "google", Issuer: "https://accounts.google.com", ClientID: "id", RedirectURL: "http://localhost"}
+			c.OIDCProviders = []OIDCProvider{provider, provider}
+		}, wantErr: "'oidc_providers':names must be unique"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := validConfig()
+			tt.modify(&cfg)
+
+			err := cfg.Validate()
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Errorf("unexpected error: %v", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Errorf("Validate() error = %v, want it to mention %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateReportsEveryError(t *testing.T) {
+	cfg := validConfig()
+	cfg.DbSource = ""
+	cfg.Port = 0
+
+	err := cfg.Validate()
+	if err == nil || !strings.Contains(err.Error(), "db_source") || !strings.Contains(err.Error(), "port") {
+		t.Errorf("Validate() error = %v, want both db_source and port", err)
+	}
+}
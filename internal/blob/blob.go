@@ -0,0 +1,17 @@
+package blob
+
+import (
+	"context"
+	"errors"
+	"io"
+)
+
+var ErrNotFound = errors.New("blob not found")
+
+// Store is implemented by anything binary objects (posters, thumbnails...) can be kept in
+type Store interface {
+	Put(ctx context.Context, key string, r io.Reader) error
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	Delete(ctx context.Context, key string) error
+	DeletePrefix(ctx context.Context, prefix string) error
+}
@@ -0,0 +1,113 @@
+package blob
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// LocalStore keeps blobs as files below a root directory on the local filesystem
+type LocalStore struct {
+	root string
+}
+
+func NewLocalStore(root string) (*LocalStore, error) {
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+
+	err = os.MkdirAll(root, 0o755)
+	if err != nil {
+		return nil, err
+	}
+
+	return &LocalStore{root: root}, nil
+}
+
+func (s *LocalStore) Put(ctx context.Context, key string, r io.Reader) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(path), 0o755)
+	if err != nil {
+		return err
+	}
+
+	// write to a temporary file first so a reader never sees a half written blob
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = io.Copy(tmp, r)
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+
+	err = tmp.Close()
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+func (s *LocalStore) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+			return nil, ErrNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return f, nil
+}
+
+func (s *LocalStore) Delete(ctx context.Context, key string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (s *LocalStore) DeletePrefix(ctx context.Context, prefix string) error {
+	path, err := s.path(prefix)
+	if err != nil {
+		return err
+	}
+
+	return os.RemoveAll(path)
+}
+
+// path maps a key to a file below the root, refusing keys that would escape it
+func (s *LocalStore) path(key string) (string, error) {
+	path := filepath.Join(s.root, filepath.FromSlash(key))
+	if path == s.root || !strings.HasPrefix(path, s.root+string(filepath.Separator)) {
+		return "", fmt.Errorf("invalid blob key %q", key)
+	}
+	return path, nil
+}
@@ -0,0 +1,197 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/s-devoe/greenlight-go/internal/jsonlog"
+)
+
+// DB sends every query to the primary, apart from the reads a store explicitly asks Reader for,
+// which are spread over the healthy read replicas. those are the movie listing, search suggestions,
+// Get and GetByExternalID, the credits loaded along with movies, the admin user listing and a user's
+// personal access token listing. once a request has written, all its reads go to the primary too,
+// so it always sees its own writes. the lookups behind authentication, tokens and lockouts stay on
+// the primary, a revoked token has to stop working at once
+type DB struct {
+	pool *pgxpool.Pool
+	// primary is the pool, or the transaction of a DB made by Store.WithTx
+	primary  Querier
+	tx       pgx.Tx
+	replicas []*replica
+	next     atomic.Uint64
+}
+
+// Querier is what the stores run their queries on, a pool or a transaction
+type Querier interface {
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+type replica struct {
+	pool *pgxpool.Pool
+	// healthy is false until the first check, and while the replica is unreachable or lags too much
+	healthy atomic.Bool
+	// checked is only used by the monitoring goroutine
+	checked bool
+}
+
+func NewDB(primary *pgxpool.Pool, replicas ...*pgxpool.Pool) *DB {
+	db := &DB{pool: primary, primary: primary}
+	for _, pool := range replicas {
+		db.replicas = append(db.replicas, &replica{pool: pool})
+	}
+	return db
+}
+
+func (db *DB) Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error) {
+	markWrite(ctx, sql)
+	return db.primary.Exec(ctx, sql, args...)
+}
+
+func (db *DB) Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error) {
+	markWrite(ctx, sql)
+	return db.primary.Query(ctx, sql, args...)
+}
+
+func (db *DB) QueryRow(ctx context.Context, sql string, args ...any) pgx.Row {
+	markWrite(ctx, sql)
+	return db.primary.QueryRow(ctx, sql, args...)
+}
+
+func (db *DB) Ping(ctx context.Context) error {
+	return db.pool.Ping(ctx)
+}
+
+// Reader returns where a read that can be slightly stale should go: a healthy replica in turn,
+// or the primary when there's none, the request has already written or it asked for ReadPrimary
+func (db *DB) Reader(ctx context.Context) Querier {
+	if len(db.replicas) == 0 || hasWritten(ctx) || readsPrimary(ctx) {
+		return db.primary
+	}
+
+	start := db.next.Add(1)
+	for i := range db.replicas {
+		r := db.replicas[(start+uint64(i))%uint64(len(db.replicas))]
+		if r.healthy.Load() {
+			return r.pool
+		}
+	}
+
+	return db.primary
+}
+
+// inTx returns a DB running every query, reads included, in tx
+func (db *DB) inTx(tx pgx.Tx) *DB {
+	return &DB{pool: db.pool, primary: tx, tx: tx}
+}
+
+// replicationLag is zero when the replica replayed everything it received, otherwise it's how
+// long ago the last replayed transaction was committed. comparing the two positions first avoids
+// reporting a lag when the primary simply had nothing to write for a while
+const replicationLag = `
+	SELECT CASE
+		WHEN NOT pg_is_in_recovery() OR pg_last_wal_receive_lsn() = pg_last_wal_replay_lsn() THEN 0
+		ELSE COALESCE(EXTRACT(EPOCH FROM now() - pg_last_xact_replay_timestamp()), 0)
+	END::float8`
+
+// MonitorReplicas checks every replica each interval until ctx is done, taking the ones that are
+// down or more than maxLag behind out of rotation and putting them back once they've caught up
+func (db *DB) MonitorReplicas(ctx context.Context, interval, maxLag time.Duration, logger *jsonlog.Logger) {
+	if len(db.replicas) == 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		for _, r := range db.replicas {
+			db.checkReplica(ctx, r, interval, maxLag, logger)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (db *DB) checkReplica(ctx context.Context, r *replica, timeout, maxLag time.Duration, logger *jsonlog.Logger) {
+	c, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var seconds float64
+	err := r.pool.QueryRow(c, replicationLag).Scan(&seconds)
+	lag := time.Duration(seconds * float64(time.Second))
+	if err == nil && lag > maxLag {
+		err = fmt.Errorf("replication lag of %s is over %s", lag.Round(time.Millisecond), maxLag)
+	}
+
+	config := r.pool.Config().ConnConfig
+	host := jsonlog.String("replica", fmt.Sprintf("%s:%d", config.Host, config.Port))
+
+	healthy := err == nil
+	changed := r.healthy.Swap(healthy) != healthy || !r.checked
+	r.checked = true
+
+	switch {
+	case changed && healthy:
+		logger.Info("database replica in rotation", host, jsonlog.Duration("lag", lag))
+	case changed:
+		logger.Warn("database replica out of rotation", host, jsonlog.Err(err))
+	}
+}
+
+type writesKey struct{}
+
+// TrackWrites returns a context that remembers whether a write was made with it, or with a context
+// derived from it, so that later reads can go to the primary. it's set up once per request
+func TrackWrites(ctx context.Context) context.Context {
+	return context.WithValue(ctx, writesKey{}, new(atomic.Bool))
+}
+
+func hasWritten(ctx context.Context) bool {
+	written, ok := ctx.Value(writesKey{}).(*atomic.Bool)
+	return ok && written.Load()
+}
+
+type primaryKey struct{}
+
+// ReadPrimary returns a context whose reads all go to the primary. it's for the reads a write is
+// based on, like loading a movie to update it: a lagging replica would return a version the update
+// then conflicts with, or no row at all for one that was just created
+func ReadPrimary(ctx context.Context) context.Context {
+	return context.WithValue(ctx, primaryKey{}, true)
+}
+
+func readsPrimary(ctx context.Context) bool {
+	primary, _ := ctx.Value(primaryKey{}).(bool)
+	return primary
+}
+
+// lockingClause matches the row locks of a SELECT: FOR UPDATE, FOR NO KEY UPDATE, FOR SHARE and FOR KEY SHARE
+var lockingClause = regexp.MustCompile(`(?i)\bfor\s+(no\s+key\s+update|update|key\s+share|share)\b`)
+
+// markWrite records a write for anything but a plain SELECT, which errs on the side of the primary.
+// a SELECT locking rows counts as a write, it's the first step of one
+func markWrite(ctx context.Context, sql string) {
+	written, ok := ctx.Value(writesKey{}).(*atomic.Bool)
+	if !ok {
+		return
+	}
+
+	sql = strings.TrimSpace(sql)
+	if len(sql) < 6 || !strings.EqualFold(sql[:6], "select") || lockingClause.MatchString(sql) {
+		written.Store(true)
+	}
+}
@@ -0,0 +1,172 @@
+package data
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// newTestPool returns a pool that never connects, pgxpool only dials when a query is run
+func newTestPool(t *testing.T) *pgxpool.Pool {
+	t.Helper()
+
+	pool, err := pgxpool.New(context.Background(), "postgres://greenlight@127.0.0.1:1/greenlight")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(pool.Close)
+
+	return pool
+}
+
+func TestMarkWrite(t *testing.T) {
+	tests := []struct {
+		sql   string
+		write bool
+	}{
+		{"SELECT id FROM movies", false},
+		{"\n\t  select id FROM movies", false},
+		{"SeLeCt 1", false},
+		{"INSERT INTO movies (title) VALUES ($1)", true},
+		{"UPDATE movies SET title = $1", true},
+		{"DELETE FROM movies", true},
+		{"WITH deleted AS (DELETE FROM tokens RETURNING id) SELECT count(*) FROM deleted", true},
+		{"SELECT failures FROM login_failures WHERE key = $1 FOR UPDATE", true},
+		{"SELECT id FROM movies WHERE id = $1\n\tfor  share", true},
+		{"SELECT id FROM movies FOR NO KEY UPDATE SKIP LOCKED", true},
+		{"SELECT id FROM movies FOR KEY SHARE", true},
+		{"SELECT id FROM movies WHERE format = 'update'", false},
+		{"sel", true},
+		{"", true},
+	}
+
+	for _, tt := range tests {
+		ctx := TrackWrites(context.Background())
+		markWrite(ctx, tt.sql)
+
+		if got := hasWritten(ctx); got != tt.write {
+			t.Errorf("markWrite(%q): written = %t, want %t", tt.sql, got, tt.write)
+		}
+	}
+}
+
+func TestMarkWriteWithoutTracking(t *testing.T) {
+	ctx := context.Background()
+	markWrite(ctx, "UPDATE movies SET title = $1")
+
+	if hasWritten(ctx) {
+		t.Error("a context without TrackWrites reports a write")
+	}
+}
+
+func TestReaderRouting(t *testing.T) {
+	primary := newTestPool(t)
+	replica := newTestPool(t)
+
+	tests := []struct {
+		name    string
+		healthy bool
+		ctx     func() context.Context
+		want    Querier
+	}{
+		{
+			name:    "read only request",
+			healthy: true,
+			ctx:     func() context.Context { return TrackWrites(context.Background()) },
+			want:    replica,
+		},
+		{
+			name:    "without write tracking",
+			healthy: true,
+			ctx:     context.Background,
+			want:    replica,
+		},
+		{
+			name:    "after a write",
+			healthy: true,
+			ctx: func() context.Context {
+				ctx := TrackWrites(context.Background())
+				markWrite(ctx, "INSERT INTO movies (title) VALUES ($1)")
+				return ctx
+			},
+			want: primary,
+		},
+		{
+			name:    "write made on a derived context",
+			healthy: true,
+			ctx: func() context.Context {
+				ctx := TrackWrites(context.Background())
+				c, cancel := context.WithCancel(ctx)
+				defer cancel()
+				markWrite(c, "UPDATE movies SET title = $1")
+				return ctx
+			},
+			want: primary,
+		},
+		{
+			name:    "after a read",
+			healthy: true,
+			ctx: func() context.Context {
+				ctx := TrackWrites(context.Background())
+				markWrite(ctx, "SELECT id FROM movies")
+				return ctx
+			},
+			want: replica,
+		},
+		{
+			name:    "read before a write",
+			healthy: true,
+			ctx:     func() context.Context { return ReadPrimary(TrackWrites(context.Background())) },
+			want:    primary,
+		},
+		{
+			name:    "unhealthy replica",
+			healthy: false,
+			ctx:     func() context.Context { return TrackWrites(context.Background()) },
+			want:    primary,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := NewDB(primary, replica)
+			db.replicas[0].healthy.Store(tt.healthy)
+
+			if got := db.Reader(tt.ctx()); got != tt.want {
+				t.Errorf("Reader() = %s, want %s", poolName(got, primary), poolName(tt.want, primary))
+			}
+		})
+	}
+}
+
+func TestReaderWithoutReplicas(t *testing.T) {
+	primary := newTestPool(t)
+	db := NewDB(primary)
+
+	if got := db.Reader(context.Background()); got != Querier(primary) {
+		t.Error("Reader() without replicas doesn't return the primary")
+	}
+}
+
+func TestReaderSkipsUnhealthyReplicas(t *testing.T) {
+	primary := newTestPool(t)
+	down := newTestPool(t)
+	up := newTestPool(t)
+
+	db := NewDB(primary, down, up)
+	db.replicas[1].healthy.Store(true)
+
+	for i := 0; i < 4; i++ {
+		if got := db.Reader(context.Background()); got != Querier(up) {
+			t.Fatalf("read %d didn't go to the healthy replica", i)
+		}
+	}
+}
+
+func poolName(q Querier, primary *pgxpool.Pool) string {
+	if q == Querier(primary) {
+		return "primary"
+	}
+	return "replica"
+}
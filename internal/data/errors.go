@@ -13,9 +13,14 @@ const (
 )
 
 var (
-	ErrRecordNotFound    = errors.New("record not found")
-	PgxErrRecordNotFound = pgx.ErrNoRows
-	ErrUpdateConflict    = errors.New("update conflict")
+	ErrRecordNotFound      = errors.New("record not found")
+	PgxErrRecordNotFound   = pgx.ErrNoRows
+	ErrUpdateConflict      = errors.New("update conflict")
+	ErrDuplicateExternalID = errors.New("duplicate external id")
+	ErrDuplicateMovie      = errors.New("duplicate movie")
+	ErrUnknownExternalID   = errors.New("unknown external id source")
+	ErrTokenReused         = errors.New("token reused")
+	ErrAccountDisabled     = errors.New("account disabled")
 )
 
 var ErrUniqueViolation = &pgconn.PgError{
@@ -29,3 +34,12 @@ func ErrorCode(err error) string {
 	}
 	return ""
 }
+
+// ErrorConstraint returns the name of the constraint, or unique index, a query violated
+func ErrorConstraint(err error) string {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return pgErr.ConstraintName
+	}
+	return ""
+}
@@ -0,0 +1,26 @@
+package data
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Ping checks a connection can be acquired from the pool and used.
+func (s Store) Ping(ctx context.Context) error {
+	return s.db.Ping(ctx)
+}
+
+// MigrationVersion returns the schema version recorded by golang-migrate, and whether the last
+// migration failed half way.
+func (s Store) MigrationVersion(ctx context.Context) (version int64, dirty bool, err error) {
+	query := `SELECT version, dirty FROM schema_migrations LIMIT 1`
+
+	err = s.db.QueryRow(ctx, query).Scan(&version, &dirty)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, false, nil
+	}
+
+	return version, dirty, err
+}
@@ -0,0 +1,144 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"time"
+)
+
+// LoginState is kept between sending a user to an external identity provider and the provider
+// redirecting them back, it protects the flow against CSRF (State), replay (Nonce) and code interception (CodeVerifier)
+type LoginState struct {
+	State        string
+	Provider     string
+	Nonce        string
+	CodeVerifier string
+	Expiry       time.Time
+	// UserID is the logged in user the identity is linked to, zero when the flow is a login
+	UserID int64
+}
+
+type IdentityStore struct {
+	DB       *DB
+	Timeouts Timeouts
+}
+
+// NewLoginState creates and stores the state of a login against provider. a non-zero userID makes
+// it the state of linking an identity to that user instead
+func (s IdentityStore) NewLoginState(ctx context.Context, provider string, userID int64, ttl time.Duration) (*LoginState, error) {
+	state := &LoginState{
+		Provider: provider,
+		Expiry:   time.Now().Add(ttl),
+		UserID:   userID,
+	}
+
+	for _, value := range []*string{&state.State, &state.Nonce, &state.CodeVerifier} {
+		randomBytes := make([]byte, 32)
+		_, err := rand.Read(randomBytes)
+		if err != nil {
+			return nil, err
+		}
+		*value = base64.RawURLEncoding.EncodeToString(randomBytes)
+	}
+
+	stmt := `INSERT INTO oidc_login_states (hash, provider, nonce, code_verifier, expiry, user_id)
+    VALUES ($1, $2, $3, $4, $5, NULLIF($6::bigint, 0))`
+
+	hash := sha256.Sum256([]byte(state.State))
+	args := []interface{}{
+		hash[:],
+		state.Provider,
+		state.Nonce,
+		state.CodeVerifier,
+		state.Expiry,
+		state.UserID,
+	}
+
+	c, cancel := context.WithTimeout(ctx, s.Timeouts.Write)
+	defer cancel()
+
+	_, err := s.DB.Exec(c, stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	return state, nil
+}
+
+// ConsumeLoginState returns and deletes the login state, so each one can only be used once
+func (s IdentityStore) ConsumeLoginState(ctx context.Context, provider, state string) (*LoginState, error) {
+	stmt := `DELETE FROM oidc_login_states
+    WHERE hash = $1 AND provider = $2 AND expiry > $3
+    RETURNING nonce, code_verifier, expiry, COALESCE(user_id, 0)`
+
+	hash := sha256.Sum256([]byte(state))
+	loginState := LoginState{State: state, Provider: provider}
+
+	c, cancel := context.WithTimeout(ctx, s.Timeouts.Write)
+	defer cancel()
+
+	err := s.DB.QueryRow(c, stmt, hash[:], provider, time.Now()).Scan(&loginState.Nonce, &loginState.CodeVerifier, &loginState.Expiry, &loginState.UserID)
+	if err != nil {
+		switch {
+		case errors.Is(err, PgxErrRecordNotFound):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &loginState, nil
+}
+
+// GetUser returns the user an external identity has been linked to
+func (s IdentityStore) GetUser(ctx context.Context, provider, subject string) (*User, error) {
+	stmt := `
+	SELECT users.id, users.name, users.email, users.password_hash, users.activated, users.disabled, users.version, users.created_at
+	FROM users
+	INNER JOIN user_identities
+	ON users.id = user_identities.user_id
+	WHERE user_identities.provider = $1
+	AND user_identities.subject = $2
+	`
+
+	var user User
+	c, cancel := context.WithTimeout(ctx, s.Timeouts.Read)
+	defer cancel()
+
+	err := s.DB.QueryRow(c, stmt, provider, subject).Scan(
+		&user.ID,
+		&user.Name,
+		&user.Email,
+		&user.Password.hash,
+		&user.Activated,
+		&user.Disabled,
+		&user.Version,
+		&user.CreatedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, PgxErrRecordNotFound):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &user, nil
+}
+
+// Link ties an external identity to a user
+func (s IdentityStore) Link(ctx context.Context, provider, subject string, userID int64) error {
+	stmt := `INSERT INTO user_identities (provider, subject, user_id)
+    VALUES ($1, $2, $3)
+    ON CONFLICT (provider, subject) DO NOTHING`
+
+	c, cancel := context.WithTimeout(ctx, s.Timeouts.Write)
+	defer cancel()
+
+	_, err := s.DB.Exec(c, stmt, provider, subject, userID)
+	return err
+}
@@ -0,0 +1,165 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+)
+
+// failed logins are counted separately per account (keyed on the email address, so probing
+// emails that don't exist is throttled just the same) and per client IP
+const (
+	LoginKindAccount = "account"
+	LoginKindIP      = "ip"
+)
+
+const (
+	// failures older than this are forgotten
+	loginFailureWindow = 15 * time.Minute
+	// number of failures allowed before every further attempt has to wait
+	loginFreeAttempts = 3
+	maxLoginDelay     = 30 * time.Second
+
+	AccountLockoutThreshold = 10
+	ipLockoutThreshold      = 50
+	LoginLockoutDuration    = 30 * time.Minute
+)
+
+type LoginFailure struct {
+	Kind         string
+	Key          string
+	Failures     int
+	BlockedUntil time.Time
+	// LockedNow is true for the failure that locked the account or IP out, not for the ones after it
+	LockedNow bool
+}
+
+// loginBlock returns how long further attempts are refused after the given number of failures.
+// the delay doubles with every failure past the free attempts, until the lockout threshold is hit
+func loginBlock(kind string, failures int) time.Duration {
+	switch {
+	case failures >= loginThreshold(kind):
+		return LoginLockoutDuration
+	case failures <= loginFreeAttempts:
+		return 0
+	}
+
+	delay := time.Second << (failures - loginFreeAttempts - 1)
+	if delay > maxLoginDelay || delay <= 0 {
+		delay = maxLoginDelay
+	}
+	return delay
+}
+
+func loginThreshold(kind string) int {
+	if kind == LoginKindAccount {
+		return AccountLockoutThreshold
+	}
+	return ipLockoutThreshold
+}
+
+// loginBlocks lists, in milliseconds, the block following each failure count from 1 to the lockout
+// threshold, for Fail to pick from in SQL
+func loginBlocks(kind string) []int64 {
+	blocks := make([]int64, loginThreshold(kind))
+	for i := range blocks {
+		blocks[i] = loginBlock(kind, i+1).Milliseconds()
+	}
+	return blocks
+}
+
+func loginKey(kind, key string) string {
+	if kind == LoginKindAccount {
+		return strings.ToLower(key)
+	}
+	return key
+}
+
+type LoginFailureStore struct {
+	DB       *DB
+	Timeouts Timeouts
+}
+
+// Blocked returns how long the account or IP has to wait before the next attempt, 0 if it can try now
+func (s LoginFailureStore) Blocked(ctx context.Context, kind, key string) (time.Duration, error) {
+	stmt := `SELECT blocked_until FROM login_failures WHERE kind = $1 AND key = $2`
+
+	c, cancel := context.WithTimeout(ctx, s.Timeouts.Read)
+	defer cancel()
+
+	var until time.Time
+	err := s.DB.QueryRow(c, stmt, kind, loginKey(kind, key)).Scan(&until)
+	if err != nil {
+		switch {
+		case errors.Is(err, PgxErrRecordNotFound):
+			return 0, nil
+		default:
+			return 0, err
+		}
+	}
+
+	wait := time.Until(until)
+	if wait < 0 {
+		return 0, nil
+	}
+	return wait, nil
+}
+
+// Fail records a failed attempt and blocks further attempts for as long as the failure count warrants.
+// the count and the block are updated by a single statement, so concurrent failures can't undo each
+// other's block, and the row is locked first to tell whether this failure is the one locking it out
+func (s LoginFailureStore) Fail(ctx context.Context, kind, key string) (*LoginFailure, error) {
+	stmt := `WITH previous AS (
+        SELECT failures, blocked_until FROM login_failures WHERE kind = $1 AND key = $2 FOR UPDATE
+    )
+    INSERT INTO login_failures (kind, key, failures, last_failed_at, blocked_until)
+    VALUES ($1, $2, 1, $3, $3 + ($5::bigint[])[1] * interval '1 millisecond')
+    ON CONFLICT (kind, key) DO UPDATE SET
+        failures = CASE WHEN login_failures.last_failed_at < $4 THEN 1 ELSE login_failures.failures + 1 END,
+        last_failed_at = EXCLUDED.last_failed_at,
+        blocked_until = EXCLUDED.last_failed_at + ($5::bigint[])[
+            CASE WHEN login_failures.last_failed_at < $4 THEN 1 ELSE LEAST(login_failures.failures + 1, cardinality($5::bigint[])) END
+        ] * interval '1 millisecond'
+    RETURNING failures, blocked_until,
+        failures >= cardinality($5::bigint[]) AND NOT COALESCE(
+            (SELECT failures >= cardinality($5::bigint[]) AND blocked_until > $3 FROM previous), false)`
+
+	c, cancel := context.WithTimeout(ctx, s.Timeouts.Write)
+	defer cancel()
+
+	now := time.Now()
+	failure := LoginFailure{Kind: kind, Key: loginKey(kind, key)}
+
+	args := []interface{}{failure.Kind, failure.Key, now, now.Add(-loginFailureWindow), loginBlocks(kind)}
+
+	err := s.DB.QueryRow(c, stmt, args...).Scan(&failure.Failures, &failure.BlockedUntil, &failure.LockedNow)
+	if err != nil {
+		return nil, err
+	}
+
+	return &failure, nil
+}
+
+// Reset forgets the failed attempts, after a successful login or when an admin unlocks an account
+func (s LoginFailureStore) Reset(ctx context.Context, kind, key string) error {
+	stmt := `DELETE FROM login_failures WHERE kind = $1 AND key = $2`
+
+	c, cancel := context.WithTimeout(ctx, s.Timeouts.Write)
+	defer cancel()
+
+	_, err := s.DB.Exec(c, stmt, kind, loginKey(kind, key))
+	return err
+}
+
+// DeleteExpired removes the failures that have aged out of the window and no longer block anything
+func (s LoginFailureStore) DeleteExpired(ctx context.Context) error {
+	stmt := `DELETE FROM login_failures WHERE last_failed_at < $1 AND blocked_until < $2`
+
+	c, cancel := context.WithTimeout(ctx, s.Timeouts.Maintenance)
+	defer cancel()
+
+	now := time.Now()
+	_, err := s.DB.Exec(c, stmt, now.Add(-loginFailureWindow), now)
+	return err
+}
@@ -0,0 +1,47 @@
+package data
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLoginBlock(t *testing.T) {
+	tests := []struct {
+		kind     string
+		failures int
+		want     time.Duration
+	}{
+		{LoginKindAccount, 1, 0},
+		{LoginKindAccount, loginFreeAttempts, 0},
+		{LoginKindAccount, loginFreeAttempts + 1, time.Second},
+		{LoginKindAccount, loginFreeAttempts + 2, 2 * time.Second},
+		{LoginKindAccount, loginFreeAttempts + 4, 8 * time.Second},
+		{LoginKindAccount, AccountLockoutThreshold - 1, maxLoginDelay},
+		{LoginKindAccount, AccountLockoutThreshold, LoginLockoutDuration},
+		{LoginKindAccount, AccountLockoutThreshold + 5, LoginLockoutDuration},
+		{LoginKindIP, AccountLockoutThreshold, maxLoginDelay},
+		{LoginKindIP, ipLockoutThreshold - 1, maxLoginDelay},
+		{LoginKindIP, ipLockoutThreshold, LoginLockoutDuration},
+	}
+
+	for _, tt := range tests {
+		if got := loginBlock(tt.kind, tt.failures); got != tt.want {
+			t.Errorf("loginBlock(%s, %d) = %s, want %s", tt.kind, tt.failures, got, tt.want)
+		}
+	}
+}
+
+func TestLoginBlocks(t *testing.T) {
+	for _, kind := range []string{LoginKindAccount, LoginKindIP} {
+		blocks := loginBlocks(kind)
+
+		if len(blocks) != loginThreshold(kind) {
+			t.Fatalf("%s: %d blocks, want one per failure up to the lockout", kind, len(blocks))
+		}
+		for i, block := range blocks {
+			if want := loginBlock(kind, i+1).Milliseconds(); block != want {
+				t.Errorf("%s: block after %d failures is %dms, want %dms", kind, i+1, block, want)
+			}
+		}
+	}
+}
@@ -5,9 +5,11 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"regexp"
+	"strings"
 	"time"
 
-	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/s-devoe/greenlight-go/internal/validator"
 )
 
@@ -18,26 +20,80 @@ type Movie struct {
 	// Runtime   int32     `json:"-"`
 	Runtime   Runtime   `json:"-"`
 	Genres    []string  `json:"genres,omitempty"`
+	ImdbID    *string   `json:"imdb_id,omitempty"`
+	TmdbID    *int64    `json:"tmdb_id,omitempty"`
 	Version   int32     `json:"version"`
 	CreatedAt time.Time `json:"-"`
+
+	// PosterFormat is the image format ("jpeg" or "png") of the movie's poster, empty when it has none
+	PosterFormat string `json:"-"`
+	// PosterID identifies the uploaded poster, every upload is stored under its own blob keys
+	PosterID string `json:"-"`
+
+	// RuntimeFormat is the format the runtime is written out in, defaults to "<n> mins"
+	RuntimeFormat RuntimeFormat `json:"-"`
+
+	// Credits are only loaded when asked for with ?include=credits, nil leaves them out of the response
+	Credits []*Credit `json:"-"`
+
+	// fields holds the sparse fieldset the movie was loaded with, nil means every field
+	fields []string
+}
+
+// Credit is a person who worked on a movie, Job is the character played for the cast
+type Credit struct {
+	Name string `json:"name"`
+	Role string `json:"role"`
+	Job  string `json:"job,omitempty"`
+}
+
+// MovieFieldSafeList is the list of fields a client can ask for with ?fields=,
+// apart from poster_url they share their names with the columns of the movies table.
+var MovieFieldSafeList = []string{"id", "title", "year", "runtime", "genres", "imdb_id", "tmdb_id", "version", "poster_url"}
+
+// titles at least this similar (pg_trgm) to an existing movie of the same year are flagged as possible duplicates
+const duplicateTitleSimilarity = 0.7
+
+var imdbIDRX = regexp.MustCompile(`^tt\d{7,10}$`)
+
+// MovieIncludeSafeList is the list of related resources that can be embedded with ?include=.
+var MovieIncludeSafeList = []string{"credits"}
+
+type MovieSuggestion struct {
+	ID         int64   `json:"id"`
+	Title      string  `json:"title"`
+	Year       int32   `json:"year"`
+	Similarity float64 `json:"similarity"`
 }
 
 type MovieStore struct {
-	DB *pgxpool.Pool
+	DB       *DB
+	Timeouts Timeouts
 }
 
 type MockMovieStore struct{}
 
-func (m MovieStore) GetAll(ctx context.Context, title string, genres []string, filters Filters) ([]*Movie, Metadata, error) {
-	stmt := fmt.Sprintf(`SELECT count(*) OVER(), id, title, year, runtime, genres, version 
+func (m MovieStore) GetAll(ctx context.Context, title string, genres []string, fuzzy bool, fields []string, filters Filters) ([]*Movie, Metadata, error) {
+	columns := movieColumns(fields)
+	if len(fields) == 0 {
+		columns = movieColumns(MovieFieldSafeList)
+	}
+
+	titleCondition := `STRPOS(LOWER(title), LOWER($1)) > 0`
+	if fuzzy {
+		// word similarity (pg_trgm) so that typos like "godfater" still match "The Godfather"
+		titleCondition = `$1 <% title`
+	}
+
+	stmt := fmt.Sprintf(`SELECT count(*) OVER(), %s 
 	FROM movies 
-	WHERE (STRPOS(LOWER(title), LOWER($1)) > 0 OR $1='') 
+	WHERE (%s OR $1='') 
 	AND (genres @>$2 OR $2 ='{}')
 	ORDER BY %s %s, id  ASC
 	LIMIT $3
-	OFFSET $4`, filters.sortColumn(), filters.sortDirection())
+	OFFSET $4`, strings.Join(columns, ", "), titleCondition, filters.sortColumn(), filters.sortDirection())
 
-	c, cancel := context.WithTimeout(ctx, 10*time.Second)
+	c, cancel := context.WithTimeout(ctx, m.Timeouts.List)
 	defer cancel()
 
 	args := []interface{}{
@@ -47,7 +103,7 @@ func (m MovieStore) GetAll(ctx context.Context, title string, genres []string, f
 		filters.offset(),
 	}
 
-	rows, err := m.DB.Query(c, stmt, args...)
+	rows, err := m.DB.Reader(c).Query(c, stmt, args...)
 	if err != nil {
 		return nil, Metadata{}, err
 	}
@@ -58,17 +114,9 @@ func (m MovieStore) GetAll(ctx context.Context, title string, genres []string, f
 	movies := []*Movie{}
 
 	for rows.Next() {
-		var movie Movie
-
-		err := rows.Scan(
-			&totalRecords,
-			&movie.ID,
-			&movie.Title,
-			&movie.Year,
-			&movie.Runtime,
-			&movie.Genres,
-			&movie.Version,
-		)
+		movie := Movie{fields: fields}
+
+		err := rows.Scan(append([]interface{}{&totalRecords}, movie.scanTargets(columns)...)...)
 		if err != nil {
 			return nil, Metadata{}, err
 		}
@@ -82,12 +130,56 @@ func (m MovieStore) GetAll(ctx context.Context, title string, genres []string, f
 	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
 	return movies, metadata, nil
 }
+
+// Suggest returns up to limit movie titles ranked by how similar they are to prefix.
+// it is meant for autocomplete, so it runs under a tight timeout and returns no
+// suggestions rather than an error when the budget is exceeded.
+func (m MovieStore) Suggest(ctx context.Context, prefix string, limit int) ([]*MovieSuggestion, error) {
+	stmt := `SELECT id, title, year, word_similarity($1, title) AS score
+	FROM movies
+	WHERE $1 <% title OR title ILIKE $2
+	ORDER BY score DESC, title ASC
+	LIMIT $3`
+
+	c, cancel := context.WithTimeout(ctx, m.Timeouts.Suggest)
+	defer cancel()
+
+	rows, err := m.DB.Reader(c).Query(c, stmt, prefix, escapeLike(prefix)+"%", limit)
+	if err != nil {
+		if pgconn.Timeout(err) {
+			return []*MovieSuggestion{}, nil
+		}
+		return nil, err
+	}
+	defer rows.Close()
+
+	suggestions := []*MovieSuggestion{}
+	for rows.Next() {
+		var suggestion MovieSuggestion
+
+		err := rows.Scan(&suggestion.ID, &suggestion.Title, &suggestion.Year, &suggestion.Similarity)
+		if err != nil {
+			return nil, err
+		}
+
+		suggestions = append(suggestions, &suggestion)
+	}
+	if err = rows.Err(); err != nil {
+		if pgconn.Timeout(err) {
+			return []*MovieSuggestion{}, nil
+		}
+		return nil, err
+	}
+
+	return suggestions, nil
+}
+
 func (m MovieStore) Insert(ctx context.Context, movie *Movie) error {
-	stmt := `INSERT INTO movies (title, year, runtime, genres)
-	VALUES ($1, $2, $3, $4)
+	stmt := `INSERT INTO movies (title, year, runtime, genres, imdb_id, tmdb_id)
+	VALUES ($1, $2, $3, $4, $5, $6)
 	RETURNING id, created_at, version`
 
-	c, cancel := context.WithTimeout(ctx, 10*time.Second)
+	c, cancel := context.WithTimeout(ctx, m.Timeouts.Write)
 
 	defer cancel()
 
@@ -96,36 +188,55 @@ func (m MovieStore) Insert(ctx context.Context, movie *Movie) error {
 		movie.Year,
 		movie.Runtime,
 		movie.Genres,
+		movie.ImdbID,
+		movie.TmdbID,
+	}
+	err := m.DB.QueryRow(c, stmt, args...).Scan(&movie.ID, &movie.CreatedAt, &movie.Version)
+	if err != nil {
+		return movieWriteError(err)
 	}
-	return m.DB.QueryRow(c, stmt, args...).Scan(&movie.ID, &movie.CreatedAt, &movie.Version)
+
+	return nil
 
 }
 
-func (m MovieStore) Get(ctx context.Context, id int64) (*Movie, error) {
+// movieWriteError maps the unique violations of an insert or update of a movie to ErrDuplicateMovie, for
+// the same title and year, or ErrDuplicateExternalID
+func movieWriteError(err error) error {
+	switch {
+	case ErrorCode(err) == UniqueViolation && ErrorConstraint(err) == "movies_title_year_key":
+		return ErrDuplicateMovie
+	case ErrorCode(err) == UniqueViolation:
+		return ErrDuplicateExternalID
+	default:
+		return err
+	}
+}
+
+// Get fetches a movie by id, when fields are given only those columns are selected
+// and the returned movie is serialized with that sparse fieldset.
+func (m MovieStore) Get(ctx context.Context, id int64, fields ...string) (*Movie, error) {
 	if id < 1 {
 		return nil, ErrRecordNotFound
 	}
-	stmt := `SELECT id, title, year, runtime, genres, version, created_at 
+
+	columns := movieColumns(fields)
+	if len(fields) == 0 {
+		columns = append(movieColumns(MovieFieldSafeList), "created_at")
+	}
+
+	stmt := fmt.Sprintf(`SELECT %s 
 	FROM movies
-	WHERE id = $1`
+	WHERE id = $1`, strings.Join(columns, ", "))
 
-	c, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	c, cancel := context.WithTimeout(ctx, m.Timeouts.Read)
 
 	defer cancel()
 
-	var movie Movie
-	row := m.DB.QueryRow(c, stmt, id)
-
-	err := row.Scan(
-		&[]byte{},
-		&movie.ID,
-		&movie.Title,
-		&movie.Year,
-		&movie.Runtime,
-		&movie.Genres,
-		&movie.Version,
-		&movie.CreatedAt,
-	)
+	movie := Movie{fields: fields}
+	row := m.DB.Reader(c).QueryRow(c, stmt, id)
+
+	err := row.Scan(movie.scanTargets(columns)...)
 
 	if err != nil {
 		switch {
@@ -139,22 +250,102 @@ func (m MovieStore) Get(ctx context.Context, id int64) (*Movie, error) {
 	return &movie, nil
 }
 
+// GetByExternalID fetches a movie by its id on an external database, a string for "imdb" and an int64
+// for "tmdb". ErrUnknownExternalID is returned for any other source, or an id of the wrong type
+func (m MovieStore) GetByExternalID(ctx context.Context, source string, externalID interface{}) (*Movie, error) {
+	var condition string
+	switch externalID.(type) {
+	case string:
+		if source == "imdb" {
+			condition = "imdb_id = $1"
+		}
+	case int64:
+		if source == "tmdb" {
+			condition = "tmdb_id = $1"
+		}
+	}
+	if condition == "" {
+		return nil, fmt.Errorf("%w: %s id of type %T", ErrUnknownExternalID, source, externalID)
+	}
+
+	columns := append(movieColumns(MovieFieldSafeList), "created_at")
+
+	stmt := fmt.Sprintf(`SELECT %s 
+	FROM movies
+	WHERE %s`, strings.Join(columns, ", "), condition)
+
+	c, cancel := context.WithTimeout(ctx, m.Timeouts.Read)
+	defer cancel()
+
+	var movie Movie
+	err := m.DB.Reader(c).QueryRow(c, stmt, externalID).Scan(movie.scanTargets(columns)...)
+	if err != nil {
+		switch {
+		case errors.Is(err, PgxErrRecordNotFound):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &movie, nil
+}
+
+// FindDuplicates returns the movies released in the same year whose title is near-identical to title
+func (m MovieStore) FindDuplicates(ctx context.Context, title string, year int32) ([]*MovieSuggestion, error) {
+	stmt := `SELECT id, title, year, similarity(title, $1) AS score
+	FROM movies
+	WHERE year = $2 AND title % $1 AND similarity(title, $1) >= $3
+	ORDER BY score DESC, id ASC
+	LIMIT 5`
+
+	c, cancel := context.WithTimeout(ctx, m.Timeouts.List)
+	defer cancel()
+
+	rows, err := m.DB.Query(c, stmt, title, year, duplicateTitleSimilarity)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	duplicates := []*MovieSuggestion{}
+	for rows.Next() {
+		var duplicate MovieSuggestion
+
+		err := rows.Scan(&duplicate.ID, &duplicate.Title, &duplicate.Year, &duplicate.Similarity)
+		if err != nil {
+			return nil, err
+		}
+
+		duplicates = append(duplicates, &duplicate)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return duplicates, nil
+}
+
 func (m MovieStore) Update(ctx context.Context, movie *Movie) error {
 	stmt := `
 	UPDATE movies
-	SET title = $1, year = $2, runtime = $3, genres = $4, version = version + 1
-	WHERE id = $5 AND version = $6
+	SET title = $1, year = $2, runtime = $3, genres = $4, imdb_id = $5, tmdb_id = $6, poster_format = $7, poster_id = $8, version = version + 1
+	WHERE id = $9 AND version = $10
 	RETURNING version`
 	args := []interface{}{
 		movie.Title,
 		movie.Year,
 		movie.Runtime,
 		movie.Genres,
+		movie.ImdbID,
+		movie.TmdbID,
+		movie.PosterFormat,
+		movie.PosterID,
 		movie.ID,
 		movie.Version,
 	}
 
-	c, cancel := context.WithTimeout(ctx, 10*time.Second)
+	c, cancel := context.WithTimeout(ctx, m.Timeouts.Write)
 	defer cancel()
 
 	err := m.DB.QueryRow(c, stmt, args...).Scan(&movie.Version)
@@ -164,7 +355,7 @@ func (m MovieStore) Update(ctx context.Context, movie *Movie) error {
 		case errors.Is(err, PgxErrRecordNotFound):
 			return ErrUpdateConflict
 		default:
-			return err
+			return movieWriteError(err)
 		}
 
 	}
@@ -178,7 +369,7 @@ func (m MovieStore) Delete(ctx context.Context, id int64) error {
 
 	stmt := `DELETE FROM movies WHERE id = $1`
 	// if  in the future i am wondering why i am using different contexts for the methods here, check Let's Go Further Chapter 8 last paragraph.
-	c, cancel := context.WithTimeout(ctx, 10*time.Second)
+	c, cancel := context.WithTimeout(ctx, m.Timeouts.Write)
 	defer cancel()
 
 	result, err := m.DB.Exec(c, stmt, id)
@@ -198,7 +389,15 @@ func (m MockMovieStore) Insert(ctx context.Context, movie *Movie) error {
 	return nil
 }
 
-func (m MockMovieStore) Get(ctx context.Context, id int64) (*Movie, error) {
+func (m MockMovieStore) Get(ctx context.Context, id int64, fields ...string) (*Movie, error) {
+	return nil, nil
+}
+
+func (m MockMovieStore) GetByExternalID(ctx context.Context, source string, externalID interface{}) (*Movie, error) {
+	return nil, nil
+}
+
+func (m MockMovieStore) FindDuplicates(ctx context.Context, title string, year int32) ([]*MovieSuggestion, error) {
 	return nil, nil
 }
 
@@ -210,28 +409,179 @@ func (m MockMovieStore) Delete(ctx context.Context, id int64) error {
 	return nil
 }
 
-func (m MockMovieStore) GetAll(ctx context.Context, title string, genres []string, filters Filters) ([]*Movie, Metadata, error) {
+func (m MockMovieStore) GetAll(ctx context.Context, title string, genres []string, fuzzy bool, fields []string, filters Filters) ([]*Movie, Metadata, error) {
 	return nil, Metadata{}, nil
 }
 
+func (m MockMovieStore) Suggest(ctx context.Context, prefix string, limit int) ([]*MovieSuggestion, error) {
+	return nil, nil
+}
+
 // custom JSON Marshal for coverting runtime for the client JSON response
 func (m Movie) MarshalJSON() ([]byte, error) {
-	var runtime string
+	var runtime interface{}
 	if m.Runtime != 0 {
-		runtime = fmt.Sprintf("%d mins", m.Runtime)
+		runtime = m.Runtime.Format(m.RuntimeFormat)
+	}
+
+	var posterURL string
+	if m.PosterFormat != "" {
+		posterURL = fmt.Sprintf("/v1/movies/%d/poster", m.ID)
 	}
 
 	type MovieAlias Movie
 
+	// an included relation with nothing in it is still written out, as an empty list
+	var credits interface{}
+	if m.Credits != nil {
+		credits = m.Credits
+	}
+
 	aux := struct {
 		MovieAlias
-		Runtime string `json:"runtime,omitempty"`
+		Runtime   interface{} `json:"runtime,omitempty"`
+		PosterURL string      `json:"poster_url,omitempty"`
+		Credits   interface{} `json:"credits,omitempty"`
 	}{
 		MovieAlias: MovieAlias(m),
 		Runtime:    runtime,
+		PosterURL:  posterURL,
+		Credits:    credits,
+	}
+
+	js, err := json.Marshal(aux)
+	if err != nil || len(m.fields) == 0 {
+		return js, err
+	}
+
+	// trim the response down to the sparse fieldset the movie was loaded with
+	var all map[string]json.RawMessage
+	err = json.Unmarshal(js, &all)
+	if err != nil {
+		return nil, err
+	}
+
+	sparse := make(map[string]json.RawMessage, len(m.fields))
+	// included relations aren't part of the fieldset
+	if value, ok := all["credits"]; ok {
+		sparse["credits"] = value
+	}
+	for _, field := range m.fields {
+		if value, ok := all[field]; ok {
+			sparse[field] = value
+		}
+	}
+
+	return json.Marshal(sparse)
+}
+
+// movieColumns returns the movies columns to select for a sparse fieldset. the id is always
+// selected, it is needed to build the poster url and is trimmed from the response if not asked for
+func movieColumns(fields []string) []string {
+	columns := []string{"id"}
+	for _, field := range fields {
+		switch field {
+		case "id":
+			continue
+		case "poster_url":
+			columns = append(columns, "poster_format", "poster_id")
+		default:
+			columns = append(columns, field)
+		}
 	}
+	return columns
+}
 
-	return json.Marshal(aux)
+// scanTargets returns the destinations for the given movies columns, in the same order
+func (m *Movie) scanTargets(columns []string) []interface{} {
+	targets := make([]interface{}, 0, len(columns))
+	for _, column := range columns {
+		switch column {
+		case "id":
+			targets = append(targets, &m.ID)
+		case "title":
+			targets = append(targets, &m.Title)
+		case "year":
+			targets = append(targets, &m.Year)
+		case "runtime":
+			targets = append(targets, &m.Runtime)
+		case "genres":
+			targets = append(targets, &m.Genres)
+		case "imdb_id":
+			targets = append(targets, &m.ImdbID)
+		case "tmdb_id":
+			targets = append(targets, &m.TmdbID)
+		case "version":
+			targets = append(targets, &m.Version)
+		case "poster_format":
+			targets = append(targets, &m.PosterFormat)
+		case "poster_id":
+			targets = append(targets, &m.PosterID)
+		case "created_at":
+			targets = append(targets, &m.CreatedAt)
+		default:
+			panic("unsafe movie column: " + column)
+		}
+	}
+	return targets
+}
+
+func ValidateMovieFields(v *validator.Validator, fields []string) {
+	for _, field := range fields {
+		v.Check(validator.In(field, MovieFieldSafeList...), "fields", fmt.Sprintf("unknown field %q", field))
+	}
+	v.Check(validator.Unique(fields), "fields", "must not contain duplicate values")
+}
+
+func ValidateMovieIncludes(v *validator.Validator, includes []string) {
+	for _, include := range includes {
+		v.Check(validator.In(include, MovieIncludeSafeList...), "include", fmt.Sprintf("unknown relation %q", include))
+	}
+}
+
+// LoadCredits fills in the credits of movies with a single query, in billing order
+func (m MovieStore) LoadCredits(ctx context.Context, movies ...*Movie) error {
+	ids := make([]int64, 0, len(movies))
+	byID := make(map[int64]*Movie, len(movies))
+	for _, movie := range movies {
+		movie.Credits = []*Credit{}
+		ids = append(ids, movie.ID)
+		byID[movie.ID] = movie
+	}
+
+	stmt := `SELECT movie_id, name, role, job
+	FROM movie_credits
+	WHERE movie_id = ANY($1)
+	ORDER BY movie_id, position, id`
+
+	c, cancel := context.WithTimeout(ctx, m.Timeouts.List)
+	defer cancel()
+
+	rows, err := m.DB.Reader(c).Query(c, stmt, ids)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var movieID int64
+		var credit Credit
+
+		err := rows.Scan(&movieID, &credit.Name, &credit.Role, &credit.Job)
+		if err != nil {
+			return err
+		}
+
+		movie := byID[movieID]
+		movie.Credits = append(movie.Credits, &credit)
+	}
+
+	return rows.Err()
+}
+
+// escapeLike escapes the LIKE wildcards in s so user input is matched literally
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
 }
 
 func ValidateMovie(v *validator.Validator, movie *Movie) {
@@ -245,4 +595,19 @@ func ValidateMovie(v *validator.Validator, movie *Movie) {
 	v.Check(movie.Genres != nil, "genres", "genres must be provided")
 	v.Check(len(movie.Genres) <= 5, "genre", "genre must not contain more than 5 genre")
 	v.Check(validator.Unique(movie.Genres), "genre", "genre must not contain duplicate values")
+
+	if movie.ImdbID != nil {
+		ValidateImdbID(v, *movie.ImdbID)
+	}
+	if movie.TmdbID != nil {
+		ValidateTmdbID(v, *movie.TmdbID)
+	}
+}
+
+func ValidateImdbID(v *validator.Validator, imdbID string) {
+	v.Check(validator.Macthes(imdbID, imdbIDRX), "imdb_id", "must be a valid IMDb id such as tt0068646")
+}
+
+func ValidateTmdbID(v *validator.Validator, tmdbID int64) {
+	v.Check(tmdbID > 0, "tmdb_id", "must be greater than zero")
 }
@@ -0,0 +1,194 @@
+package data
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/s-devoe/greenlight-go/internal/validator"
+)
+
+func TestEscapeLike(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"star wars", "star wars"},
+		{"100%", `100\%`},
+		{"snake_case", `snake\_case`},
+		{`back\slash`, `back\\slash`},
+		{`\%_`, `\\\%\_`},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		if got := escapeLike(tt.in); got != tt.want {
+			t.Errorf("escapeLike(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestMovieColumns(t *testing.T) {
+	tests := []struct {
+		fields []string
+		want   []string
+	}{
+		{nil, []string{"id"}},
+		{[]string{"id"}, []string{"id"}},
+		{[]string{"title", "year"}, []string{"id", "title", "year"}},
+		{[]string{"poster_url"}, []string{"id", "poster_format", "poster_id"}},
+		{[]string{"title", "id", "runtime"}, []string{"id", "title", "runtime"}},
+	}
+
+	for _, tt := range tests {
+		if got := movieColumns(tt.fields); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("movieColumns(%q) = %q, want %q", tt.fields, got, tt.want)
+		}
+	}
+}
+
+func TestMovieScanTargetsCoverEveryField(t *testing.T) {
+	columns := append(movieColumns(MovieFieldSafeList), "created_at")
+
+	var movie Movie
+	if got := len(movie.scanTargets(columns)); got != len(columns) {
+		t.Errorf("scanTargets returned %d targets for %d columns", got, len(columns))
+	}
+}
+
+func TestMovieScanTargetsRejectUnknownColumns(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("scanTargets accepted an unknown column")
+		}
+	}()
+
+	var movie Movie
+	movie.scanTargets([]string{"id", "password_hash"})
+}
+
+func TestMovieMarshalSparseFieldset(t *testing.T) {
+	tests := []struct {
+		name   string
+		fields []string
+		movie  Movie
+		want   []string
+	}{
+		{
+			name:   "every field",
+			fields: nil,
+			movie:  Movie{ID: 1, Title: "Moana", Year: 2016, Runtime: 107, Version: 1},
+			want:   []string{"id", "title", "year", "runtime", "version"},
+		},
+		{
+			name:   "id left out",
+			fields: []string{"title"},
+			movie:  Movie{ID: 1, Title: "Moana", Year: 2016},
+			want:   []string{"title"},
+		},
+		{
+			name:   "poster url",
+			fields: []string{"id", "poster_url"},
+			movie:  Movie{ID: 1, Title: "Moana", PosterFormat: "jpeg"},
+			want:   []string{"id", "poster_url"},
+		},
+		{
+			name:   "included credits",
+			fields: []string{"title"},
+			movie:  Movie{ID: 1, Title: "Moana", Credits: []*Credit{}},
+			want:   []string{"title", "credits"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.movie.fields = tt.fields
+
+			js, err := json.Marshal(tt.movie)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got map[string]json.RawMessage
+			err = json.Unmarshal(js, &got)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(got) != len(tt.want) {
+				t.Errorf("got %s, want the keys %q", js, tt.want)
+			}
+			for _, key := range tt.want {
+				if _, ok := got[key]; !ok {
+					t.Errorf("%s is missing %q", js, key)
+				}
+			}
+		})
+	}
+}
+
+func TestValidateMovieFields(t *testing.T) {
+	tests := []struct {
+		fields []string
+		valid  bool
+	}{
+		{[]string{"title", "year"}, true},
+		{MovieFieldSafeList, true},
+		{[]string{"title", "password"}, false},
+		{[]string{"title", "title"}, false},
+	}
+
+	for _, tt := range tests {
+		v := validator.New()
+		ValidateMovieFields(v, tt.fields)
+
+		if v.Valid() != tt.valid {
+			t.Errorf("ValidateMovieFields(%q): valid = %t, want %t", tt.fields, v.Valid(), tt.valid)
+		}
+	}
+}
+
+func TestMovieWriteError(t *testing.T) {
+	other := errors.New("connection reset")
+
+	tests := []struct {
+		err  error
+		want error
+	}{
+		{&pgconn.PgError{Code: UniqueViolation, ConstraintName: "movies_title_year_key"}, ErrDuplicateMovie},
+		{&pgconn.PgError{Code: UniqueViolation, ConstraintName: "movies_imdb_id_key"}, ErrDuplicateExternalID},
+		{&pgconn.PgError{Code: UniqueViolation, ConstraintName: "movies_tmdb_id_key"}, ErrDuplicateExternalID},
+		{other, other},
+	}
+
+	for _, tt := range tests {
+		if got := movieWriteError(tt.err); !errors.Is(got, tt.want) {
+			t.Errorf("movieWriteError(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestGetByExternalIDRejectsUnknownIDs(t *testing.T) {
+	tests := []struct {
+		source     string
+		externalID interface{}
+	}{
+		{"letterboxd", "the-godfather"},
+		{"imdb", int64(68646)},
+		{"tmdb", "238"},
+		{"tmdb", 238},
+		{"", nil},
+	}
+
+	// the id is checked before any query, the store doesn't need a database
+	var m MovieStore
+	for _, tt := range tests {
+		_, err := m.GetByExternalID(context.Background(), tt.source, tt.externalID)
+		if !errors.Is(err, ErrUnknownExternalID) {
+			t.Errorf("GetByExternalID(%q, %#v) error = %v, want %v", tt.source, tt.externalID, err, ErrUnknownExternalID)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package data
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var ErrInvalidPasswordHash = errors.New("invalid password hash")
+
+// Argon2Params are the argon2id cost parameters. they're encoded in every hash, so changing them
+// doesn't break existing hashes, which are upgraded the next time their owner logs in
+type Argon2Params struct {
+	Memory      uint32 // KiB
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+// DefaultArgon2Params follow the OWASP recommendation of 64 MiB, 3 iterations
+var DefaultArgon2Params = Argon2Params{
+	Memory:      64 * 1024,
+	Iterations:  3,
+	Parallelism: 2,
+	SaltLength:  16,
+	KeyLength:   32,
+}
+
+// PasswordHashParams are used for every new hash, main sets them from the configuration
+var PasswordHashParams = DefaultArgon2Params
+
+// hashes are stored in the PHC string format, $argon2id$v=19$m=65536,t=3,p=2$<salt>$<key>.
+// hashes from before argon2id are bcrypt's own $2a$/$2b$ format
+const argon2idPrefix = "$argon2id$"
+
+func hashPassword(plaintext string, params Argon2Params) ([]byte, error) {
+	salt := make([]byte, params.SaltLength)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return nil, err
+	}
+
+	key := argon2.IDKey([]byte(plaintext), salt, params.Iterations, params.Memory, params.Parallelism, params.KeyLength)
+
+	hash := fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s", argon2idPrefix, argon2.Version,
+		params.Memory, params.Iterations, params.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key))
+
+	return []byte(hash), nil
+}
+
+// comparePassword checks plaintext against a hash in either format. outdated reports whether the hash
+// should be replaced, because it's bcrypt or argon2id with different parameters than params
+func comparePassword(hash []byte, plaintext string, params Argon2Params) (match bool, outdated bool, err error) {
+	if !bytes.HasPrefix(hash, []byte(argon2idPrefix)) {
+		err = bcrypt.CompareHashAndPassword(hash, []byte(plaintext))
+		switch {
+		case errors.Is(err, bcrypt.ErrMismatchedHashAndPassword):
+			return false, true, nil
+		case err != nil:
+			return false, true, err
+		}
+		return true, true, nil
+	}
+
+	hashParams, salt, key, err := decodeArgon2Hash(string(hash))
+	if err != nil {
+		return false, false, err
+	}
+
+	other := argon2.IDKey([]byte(plaintext), salt, hashParams.Iterations, hashParams.Memory, hashParams.Parallelism, hashParams.KeyLength)
+	if subtle.ConstantTimeCompare(key, other) != 1 {
+		return false, false, nil
+	}
+
+	return true, hashParams != params, nil
+}
+
+func decodeArgon2Hash(hash string) (params Argon2Params, salt, key []byte, err error) {
+	// "", "argon2id", "v=19", "m=65536,t=3,p=2", salt, key
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 {
+		return params, nil, nil, ErrInvalidPasswordHash
+	}
+
+	var version int
+	_, err = fmt.Sscanf(parts[2], "v=%d", &version)
+	if err != nil || version != argon2.Version {
+		return params, nil, nil, ErrInvalidPasswordHash
+	}
+
+	_, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Iterations, &params.Parallelism)
+	if err != nil {
+		return params, nil, nil, ErrInvalidPasswordHash
+	}
+
+	salt, err = base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return params, nil, nil, ErrInvalidPasswordHash
+	}
+
+	key, err = base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return params, nil, nil, ErrInvalidPasswordHash
+	}
+
+	params.SaltLength = uint32(len(salt))
+	params.KeyLength = uint32(len(key))
+
+	return params, salt, key, nil
+}
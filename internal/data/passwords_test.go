@@ -0,0 +1,178 @@
+package data
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// cheap parameters keep the tests fast, the format doesn't depend on them
+var testArgon2Params = Argon2Params{Memory: 64, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+
+func setPasswordHashParams(t *testing.T, params Argon2Params) {
+	t.Helper()
+
+	previous := PasswordHashParams
+	PasswordHashParams = params
+	t.Cleanup(func() { PasswordHashParams = previous })
+}
+
+func TestHashPasswordFormat(t *testing.T) {
+	hash, err := hashPassword("pa55word", testArgon2Params)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(string(hash), "$argon2id$v=19$m=64,t=1,p=1$") {
+		t.Errorf("hash %s isn't in the PHC format", hash)
+	}
+
+	params, salt, key, err := decodeArgon2Hash(string(hash))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if params != testArgon2Params {
+		t.Errorf("decoded parameters %+v, want %+v", params, testArgon2Params)
+	}
+	if len(salt) != 16 || len(key) != 32 {
+		t.Errorf("salt of %d bytes and key of %d bytes, want 16 and 32", len(salt), len(key))
+	}
+
+	other, err := hashPassword("pa55word", testArgon2Params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(hash, other) {
+		t.Error("two hashes of the same password are equal, the salt isn't random")
+	}
+}
+
+func TestDecodeArgon2HashInvalid(t *testing.T) {
+	tests := map[string]string{
+		"empty":          "",
+		"missing key":    "$argon2id$v=19$m=64,t=1,p=1$c2FsdHNhbHRzYWx0c2FsdA",
+		"other version":  "$argon2id$v=16$m=64,t=1,p=1$c2FsdHNhbHRzYWx0c2FsdA$a2V5",
+		"bad parameters": "$argon2id$v=19$m=x,t=1,p=1$c2FsdHNhbHRzYWx0c2FsdA$a2V5",
+		"bad salt":       "$argon2id$v=19$m=64,t=1,p=1$!!!$a2V5",
+		"bad key":        "$argon2id$v=19$m=64,t=1,p=1$c2FsdHNhbHRzYWx0c2FsdA$!!!",
+	}
+
+	for name, hash := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, _, _, err := decodeArgon2Hash(hash)
+			if !errors.Is(err, ErrInvalidPasswordHash) {
+				t.Errorf("decodeArgon2Hash(%q) error = %v, want ErrInvalidPasswordHash", hash, err)
+			}
+		})
+	}
+}
+
+func TestComparePassword(t *testing.T) {
+	argon2Hash, err := hashPassword("pa55word", testArgon2Params)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bcryptHash, err := bcrypt.GenerateFromPassword([]byte("pa55word"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stronger := testArgon2Params
+	stronger.Iterations = 2
+
+	tests := []struct {
+		name         string
+		hash         []byte
+		plaintext    string
+		params       Argon2Params
+		wantMatch    bool
+		wantOutdated bool
+	}{
+		{"argon2id", argon2Hash, "pa55word", testArgon2Params, true, false},
+		{"argon2id wrong password", argon2Hash, "wrong", testArgon2Params, false, false},
+		{"argon2id other parameters", argon2Hash, "pa55word", stronger, true, true},
+		{"bcrypt", bcryptHash, "pa55word", testArgon2Params, true, true},
+		{"bcrypt wrong password", bcryptHash, "wrong", testArgon2Params, false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			match, outdated, err := comparePassword(tt.hash, tt.plaintext, tt.params)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if match != tt.wantMatch || outdated != tt.wantOutdated {
+				t.Errorf("comparePassword() = (%t, %t), want (%t, %t)", match, outdated, tt.wantMatch, tt.wantOutdated)
+			}
+		})
+	}
+}
+
+func TestPasswordMatchesRehash(t *testing.T) {
+	setPasswordHashParams(t, testArgon2Params)
+
+	bcryptHash, err := bcrypt.GenerateFromPassword([]byte("pa55word"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	current, err := hashPassword("pa55word", testArgon2Params)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	weaker := testArgon2Params
+	weaker.Memory = 32
+	outdated, err := hashPassword("pa55word", weaker)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name         string
+		hash         []byte
+		plaintext    string
+		wantMatch    bool
+		wantRehashed bool
+	}{
+		{"current argon2id", current, "pa55word", true, false},
+		{"outdated argon2id", outdated, "pa55word", true, true},
+		{"bcrypt", bcryptHash, "pa55word", true, true},
+		{"bcrypt wrong password", bcryptHash, "wrong", false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := password{hash: tt.hash}
+
+			match, err := p.Matches(tt.plaintext)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if match != tt.wantMatch || p.rehashed != tt.wantRehashed {
+				t.Fatalf("Matches() = %t, rehashed %t, want %t, rehashed %t", match, p.rehashed, tt.wantMatch, tt.wantRehashed)
+			}
+
+			if !tt.wantRehashed {
+				if !bytes.Equal(p.hash, tt.hash) {
+					t.Error("the hash was replaced without a rehash")
+				}
+				return
+			}
+
+			if !bytes.Equal(p.oldHash, tt.hash) {
+				t.Error("the old hash isn't kept for UpdatePasswordHash")
+			}
+
+			// the new hash uses the current parameters and still matches
+			match, outdated, err := comparePassword(p.hash, tt.plaintext, testArgon2Params)
+			if err != nil || !match || outdated {
+				t.Errorf("rehashed password: match %t, outdated %t, error %v", match, outdated, err)
+			}
+		})
+	}
+}
@@ -2,9 +2,6 @@ package data
 
 import (
 	"context"
-	"time"
-
-	"github.com/jackc/pgx/v5/pgxpool"
 )
 
 type Permissions []string
@@ -20,23 +17,35 @@ func (p Permissions) Include(code string) bool {
 	return false
 }
 
+// Intersect returns the permissions found in both p and other
+func (p Permissions) Intersect(other Permissions) Permissions {
+	intersection := Permissions{}
+	for _, code := range p {
+		if other.Include(code) {
+			intersection = append(intersection, code)
+		}
+	}
+	return intersection
+}
+
 type PermissionStore struct {
-	DB *pgxpool.Pool
+	DB       *DB
+	Timeouts Timeouts
 }
 
-func (s PermissionStore) AddPermissionsForUser(userId int64, codes ...string) error {
+func (s PermissionStore) AddPermissionsForUser(ctx context.Context, userId int64, codes ...string) error {
 	stmt := `
 	INSERT INTO users_permissions 
 	SELECT $1, permissions.id FROM permissions WHERE permissions.code = ANY($2)
 	`
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	c, cancel := context.WithTimeout(ctx, s.Timeouts.Write)
 	defer cancel()
 
-	_, err := s.DB.Exec(ctx, stmt, userId, codes)
+	_, err := s.DB.Exec(c, stmt, userId, codes)
 	return err
 }
 
-func (s PermissionStore) GetAllPermissionsForUser(userId int64) (Permissions, error) {
+func (s PermissionStore) GetAllPermissionsForUser(ctx context.Context, userId int64) (Permissions, error) {
 	stmt := `
     SELECT permissions.code
     FROM permissions 
@@ -44,10 +53,10 @@ func (s PermissionStore) GetAllPermissionsForUser(userId int64) (Permissions, er
     INNER JOIN users ON users_permissions.user_id = users.id
     WHERE users.id = $1`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	c, cancel := context.WithTimeout(ctx, s.Timeouts.Read)
 	defer cancel()
 
-	rows, err := s.DB.Query(ctx, stmt, userId)
+	rows, err := s.DB.Query(c, stmt, userId)
 	if err != nil {
 		return nil, err
 	}
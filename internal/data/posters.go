@@ -0,0 +1,61 @@
+package data
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/s-devoe/greenlight-go/internal/validator"
+)
+
+const (
+	PosterSizeOriginal = "original"
+
+	PosterMinWidth  = 200
+	PosterMinHeight = 300
+	// a decoded poster takes up to 4 bytes a pixel, 96MB at the largest
+	PosterMaxWidth  = 4000
+	PosterMaxHeight = 6000
+)
+
+// PosterWidths holds the width in pixels of the thumbnails generated for every uploaded poster
+var PosterWidths = map[string]int{
+	"small":  185,
+	"medium": 342,
+	"large":  780,
+}
+
+var PosterSizeSafeList = []string{"small", "medium", "large", PosterSizeOriginal}
+
+var PosterFormatSafeList = []string{"jpeg", "png"}
+
+// PosterKey returns the blob storage key of a movie poster in the given size and format
+func PosterKey(movieID int64, posterID, size, format string) string {
+	return fmt.Sprintf("%s/%s/%s.%s", PosterPrefix(movieID), posterID, size, format)
+}
+
+// NewPosterID returns a random id for a newly uploaded poster, so an upload never overwrites the
+// blobs of the poster the movie currently points at
+func NewPosterID() string {
+	b := make([]byte, 8)
+	// crypto/rand doesn't fail on the platforms we run on
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// PosterPrefix returns the blob storage prefix every poster of a movie is stored under
+func PosterPrefix(movieID int64) string {
+	return fmt.Sprintf("movies/%d/poster", movieID)
+}
+
+func ValidatePoster(v *validator.Validator, format string, width, height int) {
+	v.Check(validator.In(format, PosterFormatSafeList...), "poster", "must be a JPEG or PNG image")
+	v.Check(width >= PosterMinWidth, "poster", fmt.Sprintf("must be at least %d pixels wide", PosterMinWidth))
+	v.Check(height >= PosterMinHeight, "poster", fmt.Sprintf("must be at least %d pixels high", PosterMinHeight))
+	v.Check(width <= PosterMaxWidth, "poster", fmt.Sprintf("must be at most %d pixels wide", PosterMaxWidth))
+	v.Check(height <= PosterMaxHeight, "poster", fmt.Sprintf("must be at most %d pixels high", PosterMaxHeight))
+}
+
+func ValidatePosterSize(v *validator.Validator, size string) {
+	v.Check(validator.In(size, PosterSizeSafeList...), "size", "must be one of small, medium, large or original")
+}
@@ -0,0 +1,46 @@
+package data
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/s-devoe/greenlight-go/internal/validator"
+)
+
+func TestPosterKey(t *testing.T) {
+	got := PosterKey(42, "9f86d081884c7d65", "small", "jpeg")
+	if want := "movies/42/poster/9f86d081884c7d65/small.jpeg"; got != want {
+		t.Errorf("PosterKey() = %q, want %q", got, want)
+	}
+
+	if !strings.HasPrefix(got, PosterPrefix(42)+"/") {
+		t.Errorf("%q isn't under the movie's prefix %q", got, PosterPrefix(42))
+	}
+}
+
+func TestValidatePoster(t *testing.T) {
+	tests := []struct {
+		format        string
+		width, height int
+		valid         bool
+	}{
+		{"jpeg", 1000, 1500, true},
+		{"png", PosterMinWidth, PosterMinHeight, true},
+		{"png", PosterMaxWidth, PosterMaxHeight, true},
+		{"gif", 1000, 1500, false},
+		{"jpeg", PosterMinWidth - 1, 1500, false},
+		{"jpeg", 1000, PosterMinHeight - 1, false},
+		{"jpeg", PosterMaxWidth + 1, 1500, false},
+		{"jpeg", 1000, PosterMaxHeight + 1, false},
+		{"png", 6000, 9000, false},
+	}
+
+	for _, tt := range tests {
+		v := validator.New()
+		ValidatePoster(v, tt.format, tt.width, tt.height)
+
+		if v.Valid() != tt.valid {
+			t.Errorf("ValidatePoster(%s, %dx%d): valid = %t, want %t (%v)", tt.format, tt.width, tt.height, v.Valid(), tt.valid, v.Errors)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package data
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/s-devoe/greenlight-go/internal/jsonlog"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Timeouts bound every query by the kind of work it does. the caller's context
+// can only make them shorter
+type Timeouts struct {
+	// Read is for lookups of a few rows by key
+	Read time.Duration
+	// Write is for inserts, updates and deletes
+	Write time.Duration
+	// List is for searches and listings that may scan many rows
+	List time.Duration
+	// Suggest is for search-as-you-type, where a late answer is as good as none
+	Suggest time.Duration
+	// Maintenance is for the background purges
+	Maintenance time.Duration
+}
+
+// SlowQueryLogger is a pgx.QueryTracer logging, at WARN, every query taking longer than Threshold.
+// the arguments are left out, they may hold personal data
+type SlowQueryLogger struct {
+	Logger    *jsonlog.Logger
+	Threshold time.Duration
+}
+
+type queryStartKey struct{}
+
+type queryStart struct {
+	sql string
+	at  time.Time
+}
+
+func (l SlowQueryLogger) TraceQueryStart(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	return context.WithValue(ctx, queryStartKey{}, queryStart{sql: data.SQL, at: time.Now()})
+}
+
+func (l SlowQueryLogger) TraceQueryEnd(ctx context.Context, conn *pgx.Conn, data pgx.TraceQueryEndData) {
+	start, ok := ctx.Value(queryStartKey{}).(queryStart)
+	if !ok {
+		return
+	}
+
+	duration := time.Since(start.at)
+	if duration < l.Threshold {
+		return
+	}
+
+	attrs := []jsonlog.Attr{
+		jsonlog.String("sql", strings.Join(strings.Fields(start.sql), " ")),
+		jsonlog.Duration("duration", duration),
+		jsonlog.Int64("rows_affected", data.CommandTag.RowsAffected()),
+		jsonlog.Int64("pid", int64(conn.PgConn().PID())),
+	}
+	if data.Err != nil {
+		attrs = append(attrs, jsonlog.Err(data.Err))
+	}
+
+	// the trace id leads to the request the query was made for
+	if span := trace.SpanContextFromContext(ctx); span.HasTraceID() {
+		attrs = append(attrs, jsonlog.String("trace_id", span.TraceID().String()))
+	}
+
+	l.Logger.Warn("slow query", attrs...)
+}
@@ -3,14 +3,41 @@ package data
 import (
 	"errors"
 	"fmt"
+	"math"
+	"regexp"
 	"strconv"
 	"strings"
+
+	"github.com/s-devoe/greenlight-go/internal/validator"
 )
 
 type Runtime int32
 
+// RuntimeFormat controls how a runtime is written out in a JSON response
+type RuntimeFormat string
+
+const (
+	RuntimeFormatMins         RuntimeFormat = "mins"    // "102 mins", the default
+	RuntimeFormatHoursMinutes RuntimeFormat = "hm"      // "1h 42m"
+	RuntimeFormatISO8601      RuntimeFormat = "iso8601" // "PT1H42M"
+	RuntimeFormatMinutes      RuntimeFormat = "minutes" // 102
+)
+
+var RuntimeFormatSafeList = []string{
+	string(RuntimeFormatMins),
+	string(RuntimeFormatHoursMinutes),
+	string(RuntimeFormatISO8601),
+	string(RuntimeFormatMinutes),
+}
+
 var ErrInvalidRuntimeFormat = errors.New("invalid runtime format")
 
+var (
+	minsRuntimeRX         = regexp.MustCompile(`^(\d+) mins$`)
+	hoursMinutesRuntimeRX = regexp.MustCompile(`^(?:(\d+)h)?\s*(?:(\d+)m)?$`)
+	iso8601RuntimeRX      = regexp.MustCompile(`^PT(?:(\d+)H)?(?:(\d+)M)?$`)
+)
+
 // custom JSON Marshal for coverting runtime for the client JSON response
 func (r Runtime) MarshalJSON() ([]byte, error) {
 	jsonValue := fmt.Sprintf("%d mins", r)
@@ -20,20 +47,108 @@ func (r Runtime) MarshalJSON() ([]byte, error) {
 	return []byte(quotedJSONValue), nil
 }
 
-// this is for collecting the runtime as a string from the body of a request and then validate and convert it to an interger for saving
+// Format returns the runtime in the given format, a string for every format except the raw minutes
+func (r Runtime) Format(format RuntimeFormat) interface{} {
+	hours, minutes := r/60, r%60
+
+	switch format {
+	case RuntimeFormatMinutes:
+		return int32(r)
+	case RuntimeFormatHoursMinutes:
+		switch {
+		case hours == 0:
+			return fmt.Sprintf("%dm", minutes)
+		case minutes == 0:
+			return fmt.Sprintf("%dh", hours)
+		default:
+			return fmt.Sprintf("%dh %dm", hours, minutes)
+		}
+	case RuntimeFormatISO8601:
+		switch {
+		case hours == 0:
+			return fmt.Sprintf("PT%dM", minutes)
+		case minutes == 0:
+			return fmt.Sprintf("PT%dH", hours)
+		default:
+			return fmt.Sprintf("PT%dH%dM", hours, minutes)
+		}
+	default:
+		return fmt.Sprintf("%d mins", r)
+	}
+}
+
+// this is for collecting the runtime from the body of a request and then validate and convert it to an interger for saving.
+// it accepts a plain integer (102 or "102"), "102 mins", "1h 42m" and the ISO-8601 duration "PT1H42M"
 func (r *Runtime) UnmarshalJSON(jsonValue []byte) error {
+	if i, err := strconv.ParseInt(string(jsonValue), 10, 32); err == nil {
+		*r = Runtime(i)
+		return nil
+	}
+
 	unquotedJSONValue, err := strconv.Unquote(string(jsonValue))
 	if err != nil {
 		return ErrInvalidRuntimeFormat
 	}
-	parts := strings.Split(unquotedJSONValue, " ")
-	if len(parts) != 2 || parts[1] != "mins" {
+	unquotedJSONValue = strings.TrimSpace(unquotedJSONValue)
+
+	var hours, minutes string
+
+	switch {
+	case unquotedJSONValue == "":
 		return ErrInvalidRuntimeFormat
+	case minsRuntimeRX.MatchString(unquotedJSONValue):
+		minutes = minsRuntimeRX.FindStringSubmatch(unquotedJSONValue)[1]
+	case iso8601RuntimeRX.MatchString(unquotedJSONValue) && unquotedJSONValue != "PT":
+		parts := iso8601RuntimeRX.FindStringSubmatch(unquotedJSONValue)
+		hours, minutes = parts[1], parts[2]
+	case hoursMinutesRuntimeRX.MatchString(unquotedJSONValue):
+		parts := hoursMinutesRuntimeRX.FindStringSubmatch(unquotedJSONValue)
+		hours, minutes = parts[1], parts[2]
+	default:
+		i, err := strconv.ParseInt(unquotedJSONValue, 10, 32)
+		if err != nil {
+			return ErrInvalidRuntimeFormat
+		}
+		*r = Runtime(i)
+		return nil
 	}
-	i, err := strconv.ParseInt(parts[0], 10, 32)
+
+	total, err := runtimeMinutes(hours, minutes)
 	if err != nil {
 		return ErrInvalidRuntimeFormat
 	}
-	*r = Runtime(i)
+
+	*r = Runtime(total)
 	return nil
 }
+
+// runtimeMinutes adds up the (possibly empty) hours and minutes parts of a runtime
+func runtimeMinutes(hours, minutes string) (int32, error) {
+	var total int64
+
+	if hours != "" {
+		h, err := strconv.ParseInt(hours, 10, 32)
+		if err != nil {
+			return 0, err
+		}
+		total += h * 60
+	}
+
+	if minutes != "" {
+		m, err := strconv.ParseInt(minutes, 10, 32)
+		if err != nil {
+			return 0, err
+		}
+		total += m
+	}
+
+	if total > math.MaxInt32 {
+		return 0, ErrInvalidRuntimeFormat
+	}
+
+	return int32(total), nil
+}
+
+func ValidateRuntimeFormat(v *validator.Validator, format RuntimeFormat) {
+	v.Check(validator.In(string(format), RuntimeFormatSafeList...), "runtime_format", "must be one of mins, hm, iso8601 or minutes")
+}
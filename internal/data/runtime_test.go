@@ -0,0 +1,88 @@
+package data
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestRuntimeUnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		json    string
+		want    Runtime
+		wantErr error
+	}{
+		{json: `102`, want: 102},
+		{json: `"102"`, want: 102},
+		{json: `"102 mins"`, want: 102},
+		{json: `" 102 mins "`, want: 102},
+		{json: `"1h 42m"`, want: 102},
+		{json: `"1h42m"`, want: 102},
+		{json: `"2h"`, want: 120},
+		{json: `"42m"`, want: 42},
+		{json: `"PT1H42M"`, want: 102},
+		{json: `"PT2H"`, want: 120},
+		{json: `"PT42M"`, want: 42},
+		{json: `""`, wantErr: ErrInvalidRuntimeFormat},
+		{json: `"PT"`, wantErr: ErrInvalidRuntimeFormat},
+		{json: `"102 minutes"`, wantErr: ErrInvalidRuntimeFormat},
+		{json: `"1.5h"`, wantErr: ErrInvalidRuntimeFormat},
+		{json: `"abc"`, wantErr: ErrInvalidRuntimeFormat},
+		{json: `102.5`, wantErr: ErrInvalidRuntimeFormat},
+		{json: `"99999999999 mins"`, wantErr: ErrInvalidRuntimeFormat},
+		{json: `"PT35791395H"`, wantErr: ErrInvalidRuntimeFormat},
+	}
+
+	for _, tt := range tests {
+		var r Runtime
+		err := r.UnmarshalJSON([]byte(tt.json))
+
+		switch {
+		case tt.wantErr != nil && !errors.Is(err, tt.wantErr):
+			t.Errorf("UnmarshalJSON(%s): error = %v, want %v", tt.json, err, tt.wantErr)
+		case tt.wantErr == nil && err != nil:
+			t.Errorf("UnmarshalJSON(%s): unexpected error %v", tt.json, err)
+		case tt.wantErr == nil && r != tt.want:
+			t.Errorf("UnmarshalJSON(%s) = %d, want %d", tt.json, r, tt.want)
+		}
+	}
+}
+
+func TestRuntimeFormat(t *testing.T) {
+	tests := []struct {
+		runtime Runtime
+		format  RuntimeFormat
+		want    interface{}
+	}{
+		{102, RuntimeFormatMins, "102 mins"},
+		{102, "", "102 mins"},
+		{102, RuntimeFormatMinutes, int32(102)},
+		{102, RuntimeFormatHoursMinutes, "1h 42m"},
+		{120, RuntimeFormatHoursMinutes, "2h"},
+		{42, RuntimeFormatHoursMinutes, "42m"},
+		{102, RuntimeFormatISO8601, "PT1H42M"},
+		{120, RuntimeFormatISO8601, "PT2H"},
+		{42, RuntimeFormatISO8601, "PT42M"},
+	}
+
+	for _, tt := range tests {
+		if got := tt.runtime.Format(tt.format); got != tt.want {
+			t.Errorf("Runtime(%d).Format(%q) = %#v, want %#v", tt.runtime, tt.format, got, tt.want)
+		}
+	}
+}
+
+func TestRuntimeRoundTrip(t *testing.T) {
+	formats := []RuntimeFormat{RuntimeFormatMins, RuntimeFormatHoursMinutes, RuntimeFormatISO8601}
+
+	for _, runtime := range []Runtime{1, 59, 60, 61, 102, 600} {
+		for _, format := range formats {
+			formatted := runtime.Format(format).(string)
+
+			var got Runtime
+			err := got.UnmarshalJSON([]byte(`"` + formatted + `"`))
+			if err != nil || got != runtime {
+				t.Errorf("%q parsed back as %d (error %v), want %d", formatted, got, err, runtime)
+			}
+		}
+	}
+}
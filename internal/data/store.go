@@ -1,22 +1,32 @@
 package data
 
-import (
-	"github.com/jackc/pgx/v5/pgxpool"
-)
-
 type Store struct {
 	Movies      MovieStore
 	Users       UserStore
 	Tokens      TokenStore
 	Permissions PermissionStore
+	Identities  IdentityStore
+	TOTP        TOTPStore
+	Logins      LoginFailureStore
+
+	db       *DB
+	timeouts Timeouts
 }
 
-func NewStore(db *pgxpool.Pool) Store {
+// NewStore builds the stores on top of db, their queries are bound by timeouts. see DB for how
+// queries are routed between the primary and the replicas
+func NewStore(db *DB, timeouts Timeouts) Store {
 	return Store{
-		Movies:      MovieStore{DB: db},
-		Users:       UserStore{DB: db},
-		Tokens:      TokenStore{DB: db},
-		Permissions: PermissionStore{DB: db},
+		Movies:      MovieStore{DB: db, Timeouts: timeouts},
+		Users:       UserStore{DB: db, Timeouts: timeouts},
+		Tokens:      TokenStore{DB: db, Timeouts: timeouts},
+		Permissions: PermissionStore{DB: db, Timeouts: timeouts},
+		Identities:  IdentityStore{DB: db, Timeouts: timeouts},
+		TOTP:        TOTPStore{DB: db, Timeouts: timeouts},
+		Logins:      LoginFailureStore{DB: db, Timeouts: timeouts},
+
+		db:       db,
+		timeouts: timeouts,
 	}
 }
 
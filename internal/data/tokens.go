@@ -5,26 +5,53 @@ import (
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/base32"
+	"errors"
 	"time"
 
-	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/s-devoe/greenlight-go/internal/validator"
 )
 
 const (
 	ScopeActivation     = "activation"
 	ScopeAuthentication = "authentication"
+	ScopePersonalAccess = "personal-access"
+	ScopeRefresh        = "refresh"
+	ScopeMFAPending     = "mfa-pending"
+	ScopeTOTPRecovery   = "totp-recovery"
+	ScopePasswordReset  = "password-reset"
+	ScopeMagicLink      = "magic-link"
 )
 
+const (
+	// AccessTokenTTL is the lifetime of the authentication token paired with a refresh token
+	AccessTokenTTL  = 15 * time.Minute
+	RefreshTokenTTL = 30 * 24 * time.Hour
+	// MFAPendingTokenTTL is how long a user has to post their second factor after their password was accepted
+	MFAPendingTokenTTL    = 5 * time.Minute
+	PasswordResetTokenTTL = 45 * time.Minute
+	MagicLinkTokenTTL     = 15 * time.Minute
+	// recovery codes don't really expire, they are replaced when two-factor authentication is set up again
+	recoveryCodeTTL = 10 * 365 * 24 * time.Hour
+)
+
+// personal access tokens can live for a year at most
+const MaxPersonalAccessTokenTTL = 365 * 24 * time.Hour
+
 type Token struct {
-	Plaintext string    `json:"token"`
-	Hash      []byte    `json:"-"`
-	UserId    int64     `json:"-"`
-	Expiry    time.Time `json:"expiry"`
-	Scope     string    `json:"-"`
+	ID          int64       `json:"id,omitempty"`
+	Name        string      `json:"name,omitempty"`
+	Plaintext   string      `json:"token,omitempty"`
+	Hash        []byte      `json:"-"`
+	UserId      int64       `json:"-"`
+	Expiry      time.Time   `json:"expiry"`
+	Scope       string      `json:"-"`
+	Permissions Permissions `json:"permissions,omitempty"`
+	// Family ties together the refresh tokens (and the access tokens issued with them) rotated from the same login
+	Family string `json:"-"`
 }
 type TokenStore struct {
-	DB *pgxpool.Pool
+	DB       *DB
+	Timeouts Timeouts
 }
 
 func (s *TokenStore) New(ctx context.Context, userID int64, ttl time.Duration, scope string) (*Token, error) {
@@ -36,29 +63,255 @@ func (s *TokenStore) New(ctx context.Context, userID int64, ttl time.Duration, s
 	return token, err
 }
 
+// NewPersonal creates a named personal access token that expires at expiry and is restricted to permissions
+func (s *TokenStore) NewPersonal(ctx context.Context, userID int64, name string, expiry time.Time, permissions Permissions) (*Token, error) {
+	token, err := generateToken(userID, time.Until(expiry), ScopePersonalAccess)
+	if err != nil {
+		return nil, err
+	}
+	token.Name = name
+	token.Expiry = expiry
+	token.Permissions = permissions
+
+	err = s.Insert(ctx, token)
+	return token, err
+}
+
 func (s *TokenStore) Insert(ctx context.Context, token *Token) error {
-	stmt := `INSERT INTO tokens (hash, user_id, expiry, scope)
-    VALUES ($1, $2, $3, $4)`
+	stmt := `INSERT INTO tokens (hash, user_id, expiry, scope, name, permissions, family)
+    VALUES ($1, $2, $3, $4, $5, $6, NULLIF($7, ''))
+    RETURNING id`
 
 	args := []interface{}{
 		token.Hash,
 		token.UserId,
 		token.Expiry,
 		token.Scope,
+		token.Name,
+		[]string(token.Permissions),
+		token.Family,
+	}
+
+	c, cancel := context.WithTimeout(ctx, s.Timeouts.Write)
+	defer cancel()
+
+	return s.DB.QueryRow(c, stmt, args...).Scan(&token.ID)
+
+}
+
+// NewPair creates a short-lived authentication token along with a long-lived refresh token, both in
+// the given token family. an empty family starts a new one
+func (s *TokenStore) NewPair(ctx context.Context, userID int64, family string) (access *Token, refresh *Token, err error) {
+	if family == "" {
+		family, err = generateFamily()
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	access, err = generateToken(userID, AccessTokenTTL, ScopeAuthentication)
+	if err != nil {
+		return nil, nil, err
+	}
+	access.Family = family
+
+	err = s.Insert(ctx, access)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	refresh, err = s.NewRefresh(ctx, userID, family)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return access, refresh, nil
+}
+
+// NewRefresh creates a refresh token on its own, for when the authentication token isn't stored (JWTs).
+// an empty family starts a new one
+func (s *TokenStore) NewRefresh(ctx context.Context, userID int64, family string) (*Token, error) {
+	if family == "" {
+		var err error
+		family, err = generateFamily()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	refresh, err := generateToken(userID, RefreshTokenTTL, ScopeRefresh)
+	if err != nil {
+		return nil, err
+	}
+	refresh.Family = family
+
+	err = s.Insert(ctx, refresh)
+	return refresh, err
+}
+
+// Rotate consumes a refresh token and returns the user and token family it belongs to, the caller then
+// issues a new pair in that family. a refresh token can only be used once, presenting it again means it
+// leaked so the whole family is revoked and ErrTokenReused returned, along with the user
+func (s *TokenStore) Rotate(ctx context.Context, refreshPlaintext string) (userID int64, family string, err error) {
+	hash := sha256.Sum256([]byte(refreshPlaintext))
+
+	stmt := `UPDATE tokens SET used = true
+    WHERE hash = $1 AND scope = $2 AND expiry > $3 AND used = false
+    RETURNING user_id, family`
+
+	c, cancel := context.WithTimeout(ctx, s.Timeouts.Write)
+	defer cancel()
+
+	err = s.DB.QueryRow(c, stmt, hash[:], ScopeRefresh, time.Now()).Scan(&userID, &family)
+	if err == nil {
+		return userID, family, nil
+	}
+	if !errors.Is(err, PgxErrRecordNotFound) {
+		return 0, "", err
+	}
+
+	err = s.DB.QueryRow(c, `SELECT user_id, family FROM tokens WHERE hash = $1 AND scope = $2 AND used = true`, hash[:], ScopeRefresh).Scan(&userID, &family)
+	switch {
+	case errors.Is(err, PgxErrRecordNotFound):
+		return 0, "", ErrRecordNotFound
+	case err != nil:
+		return 0, "", err
+	}
+
+	err = s.DeleteFamily(ctx, family)
+	if err != nil {
+		return 0, "", err
+	}
+	return userID, "", ErrTokenReused
+}
+
+// NewRecoveryCodes replaces the recovery codes of a user with n new ones
+func (s *TokenStore) NewRecoveryCodes(ctx context.Context, userID int64, n int) ([]string, error) {
+	err := s.DeleteAllForUser(ctx, ScopeTOTPRecovery, userID)
+	if err != nil {
+		return nil, err
 	}
 
-	c, cancel := context.WithTimeout(ctx, 5*time.Second)
+	codes := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		token, err := s.New(ctx, userID, recoveryCodeTTL, ScopeTOTPRecovery)
+		if err != nil {
+			return nil, err
+		}
+		codes = append(codes, token.Plaintext)
+	}
+
+	return codes, nil
+}
+
+// Consume deletes a single-use token of a user, ErrRecordNotFound is returned if there was no such (unexpired) token
+func (s *TokenStore) Consume(ctx context.Context, scope string, userID int64, tokenPlaintext string) error {
+	hash := sha256.Sum256([]byte(tokenPlaintext))
+
+	stmt := `DELETE FROM tokens WHERE hash = $1 AND scope = $2 AND user_id = $3 AND expiry > $4`
+
+	c, cancel := context.WithTimeout(ctx, s.Timeouts.Write)
+	defer cancel()
+
+	result, err := s.DB.Exec(c, stmt, hash[:], scope, userID, time.Now())
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// Redeem deletes a single-use token, whoever it belongs to, and returns the id of its user. only one of
+// concurrent redeems of a token gets it, the others get ErrRecordNotFound like for an unknown or expired token
+func (s *TokenStore) Redeem(ctx context.Context, scope string, tokenPlaintext string) (int64, error) {
+	hash := sha256.Sum256([]byte(tokenPlaintext))
+
+	stmt := `DELETE FROM tokens WHERE hash = $1 AND scope = $2 AND expiry > $3 RETURNING user_id`
+
+	c, cancel := context.WithTimeout(ctx, s.Timeouts.Write)
+	defer cancel()
+
+	var userID int64
+	err := s.DB.QueryRow(c, stmt, hash[:], scope, time.Now()).Scan(&userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, PgxErrRecordNotFound):
+			return 0, ErrRecordNotFound
+		default:
+			return 0, err
+		}
+	}
+	return userID, nil
+}
+
+// DeleteFamily revokes every token of a token family
+func (s *TokenStore) DeleteFamily(ctx context.Context, family string) error {
+	stmt := `DELETE FROM tokens WHERE family = $1`
+
+	c, cancel := context.WithTimeout(ctx, s.Timeouts.Write)
 	defer cancel()
 
-	_, err := s.DB.Exec(c, stmt, args...)
+	_, err := s.DB.Exec(c, stmt, family)
 	return err
+}
 
+// GetAllPersonalForUser returns the personal access tokens of a user that haven't expired yet
+func (s *TokenStore) GetAllPersonalForUser(ctx context.Context, userID int64) ([]*Token, error) {
+	stmt := `SELECT id, name, expiry, permissions
+    FROM tokens
+    WHERE scope = $1 AND user_id = $2 AND expiry > $3
+    ORDER BY id ASC`
+
+	c, cancel := context.WithTimeout(ctx, s.Timeouts.List)
+	defer cancel()
+
+	rows, err := s.DB.Reader(c).Query(c, stmt, ScopePersonalAccess, userID, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := []*Token{}
+	for rows.Next() {
+		token := Token{UserId: userID, Scope: ScopePersonalAccess}
+
+		err := rows.Scan(&token.ID, &token.Name, &token.Expiry, (*[]string)(&token.Permissions))
+		if err != nil {
+			return nil, err
+		}
+
+		tokens = append(tokens, &token)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return tokens, nil
+}
+
+// DeletePersonal revokes one of the personal access tokens of a user
+func (s *TokenStore) DeletePersonal(ctx context.Context, userID, id int64) error {
+	stmt := `DELETE FROM tokens WHERE scope = $1 AND user_id = $2 AND id = $3`
+
+	c, cancel := context.WithTimeout(ctx, s.Timeouts.Write)
+	defer cancel()
+
+	result, err := s.DB.Exec(c, stmt, ScopePersonalAccess, userID, id)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
 }
 
 func (s *TokenStore) DeleteAllForUser(ctx context.Context, scope string, userID int64) error {
 	stmt := `DELETE FROM tokens WHERE scope = $1 AND user_id = $2`
 
-	c, cancel := context.WithTimeout(ctx, 5*time.Second)
+	c, cancel := context.WithTimeout(ctx, s.Timeouts.Write)
 	defer cancel()
 
 	_, err := s.DB.Exec(c, stmt, scope, userID)
@@ -71,6 +324,29 @@ func ValidateToken(v *validator.Validator, tokenPlaintext string) {
 	v.Check(len(tokenPlaintext) == 26, "token", "must be 26 characters long")
 }
 
+func ValidatePersonalAccessToken(v *validator.Validator, token *Token, granted Permissions) {
+	v.Check(token.Name != "", "name", "must be provided")
+	v.Check(len(token.Name) <= 100, "name", "must not be more than 100 characters")
+	v.Check(token.Expiry.After(time.Now()), "expiry", "must be in the future")
+	v.Check(token.Expiry.Before(time.Now().Add(MaxPersonalAccessTokenTTL)), "expiry", "must be less than a year away")
+	v.Check(len(token.Permissions) > 0, "permissions", "at least one permission must be provided")
+	v.Check(validator.Unique(token.Permissions), "permissions", "must not contain duplicate values")
+
+	for _, code := range token.Permissions {
+		v.Check(granted.Include(code), "permissions", "must be a subset of your own permissions")
+	}
+}
+
+func generateFamily() (string, error) {
+	randomBytes := make([]byte, 16)
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return "", err
+	}
+
+	return base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes), nil
+}
+
 func generateToken(userId int64, ttl time.Duration, scope string) (*Token, error) {
 
 	token := &Token{
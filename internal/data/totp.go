@@ -0,0 +1,111 @@
+package data
+
+import (
+	"context"
+	"errors"
+
+	"github.com/s-devoe/greenlight-go/internal/validator"
+)
+
+var ErrTOTPCodeReused = errors.New("totp code reused")
+
+// RecoveryCodeCount is the number of single-use recovery codes handed out when two-factor authentication is enabled
+const RecoveryCodeCount = 10
+
+// TOTP is the two-factor authentication setup of a user. it isn't Enabled until the
+// user has proven their authenticator app works by posting a first valid code
+type TOTP struct {
+	UserID       int64
+	Secret       string
+	Enabled      bool
+	LastUsedStep int64
+}
+
+type TOTPStore struct {
+	DB       *DB
+	Timeouts Timeouts
+}
+
+func (s TOTPStore) Get(ctx context.Context, userID int64) (*TOTP, error) {
+	stmt := `SELECT user_id, secret, enabled, last_used_step
+    FROM user_totp
+    WHERE user_id = $1`
+
+	c, cancel := context.WithTimeout(ctx, s.Timeouts.Read)
+	defer cancel()
+
+	var totp TOTP
+	err := s.DB.QueryRow(c, stmt, userID).Scan(&totp.UserID, &totp.Secret, &totp.Enabled, &totp.LastUsedStep)
+	if err != nil {
+		switch {
+		case errors.Is(err, PgxErrRecordNotFound):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &totp, nil
+}
+
+// SetPending replaces the (not yet enabled) secret of a user
+func (s TOTPStore) SetPending(ctx context.Context, userID int64, secret string) error {
+	stmt := `INSERT INTO user_totp (user_id, secret)
+    VALUES ($1, $2)
+    ON CONFLICT (user_id) DO UPDATE SET secret = EXCLUDED.secret, enabled = false, last_used_step = 0`
+
+	c, cancel := context.WithTimeout(ctx, s.Timeouts.Write)
+	defer cancel()
+
+	_, err := s.DB.Exec(c, stmt, userID, secret)
+	return err
+}
+
+func (s TOTPStore) Enable(ctx context.Context, userID int64) error {
+	stmt := `UPDATE user_totp SET enabled = true WHERE user_id = $1`
+
+	c, cancel := context.WithTimeout(ctx, s.Timeouts.Write)
+	defer cancel()
+
+	result, err := s.DB.Exec(c, stmt, userID)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+func (s TOTPStore) Delete(ctx context.Context, userID int64) error {
+	stmt := `DELETE FROM user_totp WHERE user_id = $1`
+
+	c, cancel := context.WithTimeout(ctx, s.Timeouts.Write)
+	defer cancel()
+
+	_, err := s.DB.Exec(c, stmt, userID)
+	return err
+}
+
+// MarkUsed records the time step of a code that was just accepted, a code of that step
+// (or an earlier one) is refused afterwards with ErrTOTPCodeReused
+func (s TOTPStore) MarkUsed(ctx context.Context, userID int64, step int64) error {
+	stmt := `UPDATE user_totp SET last_used_step = $2
+    WHERE user_id = $1 AND last_used_step < $2`
+
+	c, cancel := context.WithTimeout(ctx, s.Timeouts.Write)
+	defer cancel()
+
+	result, err := s.DB.Exec(c, stmt, userID, step)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return ErrTOTPCodeReused
+	}
+	return nil
+}
+
+func ValidateTOTPCode(v *validator.Validator, code string) {
+	v.Check(code != "", "code", "must be provided")
+}
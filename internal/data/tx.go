@@ -0,0 +1,62 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// maxTxAttempts is how many times WithTx runs a transaction that keeps failing to serialize
+const maxTxAttempts = 3
+
+// WithTx runs fn with copies of the stores bound to a single serializable transaction, committed
+// when fn returns nil and rolled back otherwise. fn's error is returned as is.
+//
+// when the transaction fails on a serialization failure or a deadlock, fn is run again in a new
+// transaction, so it must not have effects outside the database and must read again whatever it
+// updates, rather than reuse what a previous attempt loaded. called on stores that are already
+// bound to a transaction, fn simply joins it
+func (s Store) WithTx(ctx context.Context, fn func(tx Store) error) error {
+	if s.db.tx != nil {
+		return fn(s)
+	}
+
+	for attempt := 1; ; attempt++ {
+		err := s.runTx(ctx, fn)
+		if attempt == maxTxAttempts || !retryableTxError(err) {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(time.Duration(attempt) * 10 * time.Millisecond):
+		}
+	}
+}
+
+func (s Store) runTx(ctx context.Context, fn func(tx Store) error) error {
+	tx, err := s.db.pool.BeginTx(ctx, pgx.TxOptions{IsoLevel: pgx.Serializable})
+	if err != nil {
+		return err
+	}
+	// a no-op once committed
+	defer tx.Rollback(context.WithoutCancel(ctx))
+
+	err = fn(NewStore(s.db.inTx(tx), s.timeouts))
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// retryableTxError reports whether err is a serialization failure or a deadlock, after which
+// postgres expects the transaction to be retried
+func retryableTxError(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && (pgErr.Code == "40001" || pgErr.Code == "40P01")
+}
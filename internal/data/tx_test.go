@@ -0,0 +1,107 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// fakeTx stands in for a transaction WithTx has already begun, none of its methods are called
+type fakeTx struct {
+	pgx.Tx
+}
+
+func TestRetryableTxError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"serialization failure", &pgconn.PgError{Code: "40001"}, true},
+		{"deadlock", &pgconn.PgError{Code: "40P01"}, true},
+		{"wrapped serialization failure", fmt.Errorf("update movie: %w", &pgconn.PgError{Code: "40001"}), true},
+		{"unique violation", &pgconn.PgError{Code: "23505"}, false},
+		{"other error", errors.New("connection refused"), false},
+		{"update conflict", ErrUpdateConflict, false},
+		{"nil", nil, false},
+	}
+
+	for _, tt := range tests {
+		if got := retryableTxError(tt.err); got != tt.want {
+			t.Errorf("%s: retryableTxError() = %t, want %t", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestWithTxNested(t *testing.T) {
+	timeouts := Timeouts{Read: time.Second, Write: 2 * time.Second}
+
+	// the pool is nil, beginning a new transaction would panic
+	tx := fakeTx{}
+	outer := NewStore(&DB{primary: tx, tx: tx}, timeouts)
+
+	calls := 0
+	err := outer.WithTx(context.Background(), func(inner Store) error {
+		calls++
+
+		if inner.db != outer.db {
+			t.Error("the nested call didn't join the outer transaction")
+		}
+		if inner.Movies.DB != outer.db || inner.Users.DB != outer.db {
+			t.Error("the stores of the nested call aren't bound to the outer transaction")
+		}
+		if inner.Movies.Timeouts != timeouts || inner.timeouts != timeouts {
+			t.Error("the nested call lost the query timeouts")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if calls != 1 {
+		t.Errorf("fn was called %d times, want 1", calls)
+	}
+}
+
+func TestWithTxNestedReturnsError(t *testing.T) {
+	tx := fakeTx{}
+	outer := NewStore(&DB{primary: tx, tx: tx}, Timeouts{})
+
+	calls := 0
+	err := outer.WithTx(context.Background(), func(inner Store) error {
+		calls++
+		// a nested call isn't retried on its own, the outermost WithTx retries the whole transaction
+		return &pgconn.PgError{Code: "40001"}
+	})
+
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) || pgErr.Code != "40001" {
+		t.Errorf("WithTx() error = %v, want fn's error as is", err)
+	}
+	if calls != 1 {
+		t.Errorf("fn was called %d times, want 1", calls)
+	}
+}
+
+func TestInTx(t *testing.T) {
+	primary := newTestPool(t)
+	replica := newTestPool(t)
+
+	db := NewDB(primary, replica)
+	db.replicas[0].healthy.Store(true)
+
+	tx := fakeTx{}
+	txDB := db.inTx(tx)
+
+	if txDB.tx != tx || txDB.primary != Querier(tx) {
+		t.Error("inTx doesn't run queries in the transaction")
+	}
+	if got := txDB.Reader(context.Background()); got != Querier(tx) {
+		t.Error("reads of a transaction go to a replica")
+	}
+}
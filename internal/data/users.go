@@ -2,32 +2,46 @@ package data
 
 import (
 	"context"
+	"crypto/rand"
 	"crypto/sha256"
+	"encoding/base64"
 	"errors"
+	"fmt"
 	"time"
 
-	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/s-devoe/greenlight-go/internal/pwned"
 	"github.com/s-devoe/greenlight-go/internal/validator"
-	"golang.org/x/crypto/bcrypt"
 )
 
 var AnonymousUser = &User{}
 
 type User struct {
-	ID        int64     `json:"id"`
-	Name      string    `json:"name"`
-	Email     string    `json:"email"`
-	Password  password  `json:"-"`
-	Activated bool      `json:"activated"`
+	ID        int64    `json:"id"`
+	Name      string   `json:"name"`
+	Email     string   `json:"email"`
+	Password  password `json:"-"`
+	Activated bool     `json:"activated"`
+	// Disabled accounts were deactivated by an admin and can't log in, unlike ones not Activated yet
+	Disabled  bool      `json:"disabled"`
 	Version   int       `json:"version"`
 	CreatedAt time.Time `json:"created_at"`
 }
 
 type MockUserStore struct{}
 
+// TokenState is what JWTs are checked against, they can't be revoked themselves. a JWT is only
+// accepted while the token version it was issued with is the user's current one
+type TokenState struct {
+	Version  int
+	Disabled bool
+}
+
 type password struct {
 	plaintext *string
 	hash      []byte
+	// rehashed is set by Matches when it replaced an outdated hash, see UserStore.UpdatePasswordHash
+	rehashed bool
+	oldHash  []byte
 }
 
 var (
@@ -35,7 +49,8 @@ var (
 )
 
 type UserStore struct {
-	DB *pgxpool.Pool
+	DB       *DB
+	Timeouts Timeouts
 }
 
 func (u *User) IsAnonymous() bool {
@@ -43,11 +58,11 @@ func (u *User) IsAnonymous() bool {
 }
 
 // get the user associated with a token
-func (s UserStore) GetForToken(tokenScope, tokenPlainText string) (*User, error) {
+func (s UserStore) GetForToken(ctx context.Context, tokenScope, tokenPlainText string) (*User, error) {
 	tokenHash := sha256.Sum256([]byte(tokenPlainText))
 
 	stmt := `
-	SELECT users.id, users.name, users.email, users.password_hash, users.activated, users.version, users.created_at
+	SELECT users.id, users.name, users.email, users.password_hash, users.activated, users.disabled, users.version, users.created_at
 	FROM users
 	INNER JOIN tokens
 	ON users.id = tokens.user_id
@@ -62,15 +77,16 @@ func (s UserStore) GetForToken(tokenScope, tokenPlainText string) (*User, error)
 	}
 
 	var user User
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	c, cancel := context.WithTimeout(ctx, s.Timeouts.Read)
 	defer cancel()
 
-	err := s.DB.QueryRow(ctx, stmt, args...).Scan(
+	err := s.DB.QueryRow(c, stmt, args...).Scan(
 		&user.ID,
 		&user.Name,
 		&user.Email,
 		&user.Password.hash,
 		&user.Activated,
+		&user.Disabled,
 		&user.Version,
 		&user.CreatedAt,
 	)
@@ -87,6 +103,59 @@ func (s UserStore) GetForToken(tokenScope, tokenPlainText string) (*User, error)
 
 }
 
+// GetForAuthenticationToken returns the user owning an authentication or personal access token, along with
+// the permissions the token is restricted to. the permissions are nil when the token isn't restricted.
+// ErrAccountDisabled is returned when the user is disabled, whatever the kind of token
+func (s UserStore) GetForAuthenticationToken(ctx context.Context, tokenPlainText string) (*User, Permissions, error) {
+	tokenHash := sha256.Sum256([]byte(tokenPlainText))
+
+	stmt := `
+	SELECT users.id, users.name, users.email, users.password_hash, users.activated, users.disabled, users.version, users.created_at, tokens.permissions
+	FROM users
+	INNER JOIN tokens
+	ON users.id = tokens.user_id
+	WHERE tokens.hash = $1
+	AND tokens.scope = ANY($2)
+	AND tokens.expiry > $3
+	`
+	args := []interface{}{
+		tokenHash[:],
+		[]string{ScopeAuthentication, ScopePersonalAccess},
+		time.Now(),
+	}
+
+	var user User
+	var permissions []string
+	c, cancel := context.WithTimeout(ctx, s.Timeouts.Read)
+	defer cancel()
+
+	err := s.DB.QueryRow(c, stmt, args...).Scan(
+		&user.ID,
+		&user.Name,
+		&user.Email,
+		&user.Password.hash,
+		&user.Activated,
+		&user.Disabled,
+		&user.Version,
+		&user.CreatedAt,
+		&permissions,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, PgxErrRecordNotFound):
+			return nil, nil, ErrRecordNotFound
+		default:
+			return nil, nil, err
+		}
+	}
+
+	if user.Disabled {
+		return nil, nil, ErrAccountDisabled
+	}
+
+	return &user, permissions, nil
+}
+
 func (s *UserStore) Insert(ctx context.Context, user *User) error {
 	stmt := `
     INSERT INTO users (name, email, password_hash, activated)
@@ -101,7 +170,7 @@ func (s *UserStore) Insert(ctx context.Context, user *User) error {
 		user.Activated,
 	}
 
-	c, cancel := context.WithTimeout(ctx, 3*time.Second)
+	c, cancel := context.WithTimeout(ctx, s.Timeouts.Write)
 	defer cancel()
 
 	err := s.DB.QueryRow(c, stmt, args...).Scan(
@@ -123,14 +192,46 @@ func (s *UserStore) Insert(ctx context.Context, user *User) error {
 	return nil
 }
 
+func (s *UserStore) Get(ctx context.Context, id int64) (*User, error) {
+	stmt := `
+    SELECT id, name, email, password_hash, activated, disabled, version, created_at
+    FROM users
+    WHERE id = $1
+    `
+	var user User
+	c, cancel := context.WithTimeout(ctx, s.Timeouts.Read)
+	defer cancel()
+
+	err := s.DB.QueryRow(c, stmt, id).Scan(
+		&user.ID,
+		&user.Name,
+		&user.Email,
+		&user.Password.hash,
+		&user.Activated,
+		&user.Disabled,
+		&user.Version,
+		&user.CreatedAt,
+	)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, PgxErrRecordNotFound):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &user, nil
+}
+
 func (s *UserStore) GetByEmail(ctx context.Context, email string) (*User, error) {
 	stmt := `
-    SELECT id, name, email, password_hash, activated, version, created_at
+    SELECT id, name, email, password_hash, activated, disabled, version, created_at
     FROM users
     WHERE email = $1
     `
 	var user User
-	c, cancel := context.WithTimeout(ctx, 3*time.Second)
+	c, cancel := context.WithTimeout(ctx, s.Timeouts.Read)
 	defer cancel()
 
 	err := s.DB.QueryRow(c, stmt, email).Scan(
@@ -139,6 +240,7 @@ func (s *UserStore) GetByEmail(ctx context.Context, email string) (*User, error)
 		&user.Email,
 		&user.Password.hash,
 		&user.Activated,
+		&user.Disabled,
 		&user.Version,
 		&user.CreatedAt,
 	)
@@ -154,11 +256,72 @@ func (s *UserStore) GetByEmail(ctx context.Context, email string) (*User, error)
 	return &user, nil
 }
 
+// GetAll lists users for admins, search matches the name or email address. activated and disabled
+// are left out of the filtering when nil
+func (s *UserStore) GetAll(ctx context.Context, search string, activated, disabled *bool, filters Filters) ([]*User, Metadata, error) {
+	stmt := fmt.Sprintf(`SELECT count(*) OVER(), id, name, email, password_hash, activated, disabled, version, created_at
+	FROM users
+	WHERE (STRPOS(LOWER(name), LOWER($1)) > 0 OR STRPOS(LOWER(email), LOWER($1)) > 0 OR $1 = '')
+	AND (activated = $2 OR $2 IS NULL)
+	AND (disabled = $3 OR $3 IS NULL)
+	ORDER BY %s %s, id ASC
+	LIMIT $4
+	OFFSET $5`, filters.sortColumn(), filters.sortDirection())
+
+	c, cancel := context.WithTimeout(ctx, s.Timeouts.List)
+	defer cancel()
+
+	args := []interface{}{
+		search,
+		activated,
+		disabled,
+		filters.limit(),
+		filters.offset(),
+	}
+
+	rows, err := s.DB.Reader(c).Query(c, stmt, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	defer rows.Close()
+
+	totalRecords := 0
+	users := []*User{}
+
+	for rows.Next() {
+		var user User
+
+		err := rows.Scan(
+			&totalRecords,
+			&user.ID,
+			&user.Name,
+			&user.Email,
+			&user.Password.hash,
+			&user.Activated,
+			&user.Disabled,
+			&user.Version,
+			&user.CreatedAt,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		users = append(users, &user)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return users, metadata, nil
+}
+
 func (s *UserStore) UpdateUser(ctx context.Context, user *User) error {
 	stmt := `
     UPDATE users
-    SET name = $1, email = $2, password_hash = $3, activated = $4, version = version + 1
-    WHERE id = $5 AND version = $6
+    SET name = $1, email = $2, password_hash = $3, activated = $4, disabled = $5, version = version + 1
+    WHERE id = $6 AND version = $7
     RETURNING version
     `
 	args := []interface{}{
@@ -166,10 +329,11 @@ func (s *UserStore) UpdateUser(ctx context.Context, user *User) error {
 		user.Email,
 		user.Password.hash,
 		user.Activated,
+		user.Disabled,
 		user.ID,
 		user.Version,
 	}
-	c, cancel := context.WithTimeout(ctx, 3*time.Second)
+	c, cancel := context.WithTimeout(ctx, s.Timeouts.Write)
 	defer cancel()
 
 	err := s.DB.QueryRow(c, stmt, args...).Scan(&user.Version)
@@ -189,16 +353,121 @@ func (s *UserStore) UpdateUser(ctx context.Context, user *User) error {
 	return nil
 }
 
+// Delete removes a user, their tokens, permissions and linked identities go with them
+func (s *UserStore) Delete(ctx context.Context, id int64) error {
+	stmt := `DELETE FROM users WHERE id = $1`
+
+	c, cancel := context.WithTimeout(ctx, s.Timeouts.Write)
+	defer cancel()
+
+	result, err := s.DB.Exec(c, stmt, id)
+	if err != nil {
+		return err
+	}
+
+	if result.RowsAffected() == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// GetTokenState returns the token version and disabled flag of a user. it reads from the primary,
+// a lagging replica would let revoked JWTs through
+func (s *UserStore) GetTokenState(ctx context.Context, id int64) (*TokenState, error) {
+	stmt := `SELECT token_version, disabled FROM users WHERE id = $1`
+
+	c, cancel := context.WithTimeout(ctx, s.Timeouts.Read)
+	defer cancel()
+
+	var state TokenState
+	err := s.DB.QueryRow(c, stmt, id).Scan(&state.Version, &state.Disabled)
+	if err != nil {
+		switch {
+		case errors.Is(err, PgxErrRecordNotFound):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &state, nil
+}
+
+// IncrementTokenVersion invalidates every JWT issued to a user so far
+func (s *UserStore) IncrementTokenVersion(ctx context.Context, id int64) error {
+	stmt := `UPDATE users SET token_version = token_version + 1 WHERE id = $1`
+
+	c, cancel := context.WithTimeout(ctx, s.Timeouts.Write)
+	defer cancel()
+
+	_, err := s.DB.Exec(c, stmt, id)
+	return err
+}
+
+// UpdatePasswordHash saves a hash upgraded by password.Matches. it only replaces the hash that was
+// checked, so a password changed in the meantime isn't overwritten, and it leaves the version alone
+// since the password itself didn't change
+func (s *UserStore) UpdatePasswordHash(ctx context.Context, user *User) error {
+	if !user.Password.rehashed {
+		return nil
+	}
+
+	stmt := `UPDATE users SET password_hash = $1 WHERE id = $2 AND password_hash = $3`
+
+	c, cancel := context.WithTimeout(ctx, s.Timeouts.Write)
+	defer cancel()
+
+	_, err := s.DB.Exec(c, stmt, user.Password.hash, user.ID, user.Password.oldHash)
+	if err != nil {
+		return err
+	}
+
+	user.Password.rehashed = false
+	user.Password.oldHash = nil
+	return nil
+}
+
 func ValidateEmail(v *validator.Validator, email string) {
 	v.Check(email != "", "email", "please enter a valid email")
 	v.Check(validator.Macthes(email, validator.EmailRegex), "email", "please enter a valid email address")
 
 }
 
+const (
+	minPasswordLength = 6
+	maxPasswordLength = 256
+)
+
 func ValidatePasswordPlaintext(v *validator.Validator, password string) {
 	v.Check(password != "", "password", "password must be provided")
-	v.Check(len(password) > 5, "password", "password must be at least 6 characters")
-	v.Check(len(password) <= 20, "password", "password must be at most 20 characters")
+	v.Check(len(password) >= minPasswordLength, "password", fmt.Sprintf("password must be at least %d characters", minPasswordLength))
+	v.Check(len(password) <= maxPasswordLength, "password", fmt.Sprintf("password must be at most %d characters", maxPasswordLength))
+}
+
+// BreachedPasswords is the optional local copy of the Have I Been Pwned hashes, main opens it when configured
+var BreachedPasswords *pwned.Index
+
+// ValidateNewPassword checks a password being set, on top of the length checks it rejects passwords
+// that are too common or known from data breaches, which would be the first ones guessed.
+// logging in only checks the length, so accounts with such passwords can still log in and change them
+func ValidateNewPassword(v *validator.Validator, password string) {
+	ValidatePasswordPlaintext(v, password)
+	if len(password) < minPasswordLength || len(password) > maxPasswordLength {
+		return
+	}
+
+	if pwned.IsCommon(password) {
+		v.AddError("password", "password is one of the most commonly used passwords, please choose another")
+		return
+	}
+
+	if BreachedPasswords != nil {
+		count, err := BreachedPasswords.Count(password)
+		// an unreadable breach list shouldn't stop anyone from registering, the common list still applies
+		if err == nil && count > 0 {
+			v.AddError("password", fmt.Sprintf("password has appeared in %d known data breaches, please choose another", count))
+		}
+	}
 }
 
 func ValidateUser(v *validator.Validator, user *User) {
@@ -207,7 +476,7 @@ func ValidateUser(v *validator.Validator, user *User) {
 	ValidateEmail(v, user.Email)
 
 	if user.Password.plaintext != nil {
-		ValidatePasswordPlaintext(v, *user.Password.plaintext)
+		ValidateNewPassword(v, *user.Password.plaintext)
 	}
 
 	if user.Password.hash == nil {
@@ -216,7 +485,7 @@ func ValidateUser(v *validator.Validator, user *User) {
 }
 
 func (p *password) Set(plaintextPassword string) error {
-	hash, err := bcrypt.GenerateFromPassword([]byte(plaintextPassword), 12)
+	hash, err := hashPassword(plaintextPassword, PasswordHashParams)
 	if err != nil {
 		return err
 	}
@@ -227,17 +496,35 @@ func (p *password) Set(plaintextPassword string) error {
 	return nil
 }
 
+// SetRandom sets a random password nobody knows, for accounts provisioned from an external identity provider
+func (p *password) SetRandom() error {
+	randomBytes := make([]byte, 15)
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return err
+	}
+
+	return p.Set(base64.RawURLEncoding.EncodeToString(randomBytes))
+}
+
+// Matches checks the password against the stored hash. when the hash is outdated (bcrypt, or argon2id
+// with other parameters than PasswordHashParams) and the password matches, the hash is replaced in
+// memory, the caller saves it with UserStore.UpdatePasswordHash
 func (p *password) Matches(plaintextPassword string) (bool, error) {
-	err := bcrypt.CompareHashAndPassword(p.hash, []byte(plaintextPassword))
+	match, outdated, err := comparePassword(p.hash, plaintextPassword, PasswordHashParams)
+	if err != nil || !match {
+		return false, err
+	}
 
-	if err != nil {
-		switch {
-		case errors.Is(err, bcrypt.ErrMismatchedHashAndPassword):
-			return false, nil
-		default:
+	if outdated {
+		oldHash := p.hash
+		err = p.Set(plaintextPassword)
+		if err != nil {
 			return false, err
 		}
-
+		p.rehashed = true
+		p.oldHash = oldHash
 	}
+
 	return true, nil
 }
@@ -0,0 +1,89 @@
+package images
+
+import (
+	"errors"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"net/http"
+
+	"golang.org/x/image/draw"
+)
+
+var ErrUnsupportedFormat = errors.New("unsupported image format")
+
+// DecodeConfig sniffs the content of r and returns the image format ("jpeg" or "png") and its
+// dimensions without decoding the whole image. r is rewound so the image can be decoded afterwards
+func DecodeConfig(r io.ReadSeeker) (string, image.Config, error) {
+	head := make([]byte, 512)
+	n, err := io.ReadFull(r, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return "", image.Config{}, err
+	}
+
+	var format string
+	switch http.DetectContentType(head[:n]) {
+	case "image/jpeg":
+		format = "jpeg"
+	case "image/png":
+		format = "png"
+	default:
+		return "", image.Config{}, ErrUnsupportedFormat
+	}
+
+	_, err = r.Seek(0, io.SeekStart)
+	if err != nil {
+		return "", image.Config{}, err
+	}
+
+	cfg, _, err := image.DecodeConfig(r)
+	if err != nil {
+		return "", image.Config{}, err
+	}
+
+	_, err = r.Seek(0, io.SeekStart)
+	return format, cfg, err
+}
+
+func Decode(r io.Reader) (image.Image, error) {
+	img, _, err := image.Decode(r)
+	return img, err
+}
+
+// Thumbnail scales img down to the given width, keeping its aspect ratio. images that are
+// already narrower than width are returned as they are
+func Thumbnail(img image.Image, width int) image.Image {
+	bounds := img.Bounds()
+	if bounds.Dx() <= width {
+		return img
+	}
+
+	height := bounds.Dy() * width / bounds.Dx()
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, bounds, draw.Over, nil)
+
+	return dst
+}
+
+func Encode(w io.Writer, img image.Image, format string) error {
+	switch format {
+	case "jpeg":
+		return jpeg.Encode(w, img, &jpeg.Options{Quality: 85})
+	case "png":
+		return png.Encode(w, img)
+	default:
+		return ErrUnsupportedFormat
+	}
+}
+
+func ContentType(format string) string {
+	switch format {
+	case "jpeg":
+		return "image/jpeg"
+	case "png":
+		return "image/png"
+	default:
+		return "application/octet-stream"
+	}
+}
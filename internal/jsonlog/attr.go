@@ -0,0 +1,65 @@
+package jsonlog
+
+import (
+	"fmt"
+	"time"
+)
+
+// Attr is a typed property of a log entry, written to the JSON output as a string, number, bool or object
+type Attr struct {
+	Key   string
+	Value interface{}
+}
+
+func String(key, value string) Attr {
+	return Attr{Key: key, Value: value}
+}
+
+func Int(key string, value int) Attr {
+	return Attr{Key: key, Value: value}
+}
+
+func Int64(key string, value int64) Attr {
+	return Attr{Key: key, Value: value}
+}
+
+func Float64(key string, value float64) Attr {
+	return Attr{Key: key, Value: value}
+}
+
+func Bool(key string, value bool) Attr {
+	return Attr{Key: key, Value: value}
+}
+
+// Duration is written as a string, like 1.5s, which reads better than nanoseconds
+func Duration(key string, value time.Duration) Attr {
+	return Attr{Key: key, Value: value.String()}
+}
+
+func Time(key string, value time.Time) Attr {
+	return Attr{Key: key, Value: value.UTC().Format(time.RFC3339Nano)}
+}
+
+// Err writes the message of err under the "error" key
+func Err(err error) Attr {
+	return Attr{Key: "error", Value: err.Error()}
+}
+
+// Any is written however encoding/json marshals value, errors and Stringers as their text
+func Any(key string, value interface{}) Attr {
+	switch v := value.(type) {
+	case error:
+		return Attr{Key: key, Value: v.Error()}
+	case fmt.Stringer:
+		return Attr{Key: key, Value: v.String()}
+	}
+	return Attr{Key: key, Value: value}
+}
+
+func mapAttrs(properties map[string]string) []Attr {
+	attrs := make([]Attr, 0, len(properties))
+	for key, value := range properties {
+		attrs = append(attrs, String(key, value))
+	}
+	return attrs
+}
@@ -2,66 +2,140 @@ package jsonlog
 
 import (
 	"encoding/json"
+	"fmt"
 	"io"
 	"os"
 	"runtime/debug"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 type Level int8
 
 const (
-	LevelInfo  Level = iota //has the level 0
-	LevelError              //has the level 1
-	LevelFatal              //has the level 2
-	LevelOff                //has the level 3
+	LevelDebug Level = iota //has the level 0
+	LevelInfo               //has the level 1
+	LevelWarn               //has the level 2
+	LevelError              //has the level 3
+	LevelFatal              //has the level 4
+	LevelOff                //has the level 5
 )
 
 func (l Level) String() string {
 	switch l {
+	case LevelDebug:
+		return "DEBUG"
 	case LevelInfo:
 		return "INFO"
+	case LevelWarn:
+		return "WARN"
 	case LevelError:
 		return "ERROR"
 	case LevelFatal:
 		return "FATAL"
+	case LevelOff:
+		return "OFF"
 	default:
 		return ""
 	}
 }
 
-type Logger struct {
+// ParseLevel is the reverse of Level.String, ignoring case
+func ParseLevel(s string) (Level, error) {
+	for l := LevelDebug; l <= LevelOff; l++ {
+		if strings.EqualFold(s, l.String()) {
+			return l, nil
+		}
+	}
+	return LevelInfo, fmt.Errorf("jsonlog: unknown level %q", s)
+}
+
+// core is what a Logger shares with the child loggers made from it by With
+type core struct {
 	out      io.Writer
-	minLevel Level
+	minLevel atomic.Int32
 	mu       sync.Mutex
+	sampler  atomic.Pointer[sampler]
+}
+
+type Logger struct {
+	core  *core
+	attrs []Attr
 }
 
 func New(out io.Writer, minLevel Level) *Logger {
+	c := &core{out: out}
+	c.minLevel.Store(int32(minLevel))
+	return &Logger{core: c}
+}
+
+// With returns a child logger adding attrs to every entry. it shares the output, level and sampling of l
+func (l *Logger) With(attrs ...Attr) *Logger {
 	return &Logger{
-		out:      out,
-		minLevel: minLevel,
+		core:  l.core,
+		attrs: append(append([]Attr{}, l.attrs...), attrs...),
+	}
+}
+
+// SetLevel changes the minimum level at runtime, for l and every logger sharing its output
+func (l *Logger) SetLevel(level Level) {
+	l.core.minLevel.Store(int32(level))
+}
+
+func (l *Logger) Level() Level {
+	return Level(l.core.minLevel.Load())
+}
+
+func (l *Logger) Enabled(level Level) bool {
+	return level >= l.Level() && level < LevelOff
+}
+
+// SetSampling limits noisy messages: within every tick, only the first entries with the same level and
+// message are written, then one in every thereafter. ERROR and FATAL entries are never sampled.
+// a first of 0 turns sampling off
+func (l *Logger) SetSampling(first, thereafter int, tick time.Duration) {
+	if first <= 0 {
+		l.core.sampler.Store(nil)
+		return
 	}
+	l.core.sampler.Store(newSampler(first, thereafter, tick))
 }
 
-func (l *Logger) print(level Level, message string, properties map[string]string) (int, error) {
-	if level < l.minLevel {
+func (l *Logger) print(level Level, message string, attrs []Attr) (int, error) {
+	if !l.Enabled(level) {
 		return 0, nil
 	}
+
+	if s := l.core.sampler.Load(); s != nil && level < LevelError && !s.allow(level, message) {
+		return 0, nil
+	}
+
 	aux := struct {
-		Level      string            `json:"level"`
-		Time       string            `json:"time"`
-		Message    string            `json:"message"`
-		Properties map[string]string `json:"properties"`
-		Trace      string            `json:"trace"`
+		Level      string                 `json:"level"`
+		Time       string                 `json:"time"`
+		Message    string                 `json:"message"`
+		Properties map[string]interface{} `json:"properties,omitempty"`
+		Trace      string                 `json:"trace,omitempty"`
 	}{
-		Level:      level.String(),
-		Time:       time.Now().UTC().Format(time.RFC3339),
-		Message:    message,
-		Properties: properties,
+		Level:   level.String(),
+		Time:    time.Now().UTC().Format(time.RFC3339),
+		Message: message,
 	}
 
-	if level >= LevelError {
+	if len(l.attrs)+len(attrs) > 0 {
+		aux.Properties = make(map[string]interface{}, len(l.attrs)+len(attrs))
+		for _, attr := range l.attrs {
+			aux.Properties[attr.Key] = attr.Value
+		}
+		for _, attr := range attrs {
+			aux.Properties[attr.Key] = attr.Value
+		}
+	}
+
+	// stack traces are big, errors only carry one when debugging
+	if level == LevelFatal || (level == LevelError && l.Level() == LevelDebug) {
 		aux.Trace = string(debug.Stack())
 	}
 
@@ -72,22 +146,43 @@ func (l *Logger) print(level Level, message string, properties map[string]string
 		line = []byte(LevelError.String() + ":unable to marshal log message" + err.Error())
 	}
 
-	l.mu.Lock()
-	defer l.mu.Unlock()
+	l.core.mu.Lock()
+	defer l.core.mu.Unlock()
+
+	return l.core.out.Write(append(line, '\n'))
+}
+
+func (l *Logger) Debug(message string, attrs ...Attr) {
+	l.print(LevelDebug, message, attrs)
+}
+
+func (l *Logger) Info(message string, attrs ...Attr) {
+	l.print(LevelInfo, message, attrs)
+}
+
+func (l *Logger) Warn(message string, attrs ...Attr) {
+	l.print(LevelWarn, message, attrs)
+}
+
+func (l *Logger) Error(err error, attrs ...Attr) {
+	l.print(LevelError, err.Error(), attrs)
+}
 
-	return l.out.Write(append(line, '\n'))
+func (l *Logger) Fatal(err error, attrs ...Attr) {
+	l.print(LevelFatal, err.Error(), attrs)
+	os.Exit(1)
 }
 
 func (l *Logger) PrintInfo(message string, properties map[string]string) {
-	l.print(LevelInfo, message, properties)
+	l.print(LevelInfo, message, mapAttrs(properties))
 }
 
 func (l *Logger) PrintError(err error, properties map[string]string) {
-	l.print(LevelError, err.Error(), properties)
+	l.print(LevelError, err.Error(), mapAttrs(properties))
 }
 
 func (l *Logger) PrintFatal(err error, properties map[string]string) {
-	l.print(LevelFatal, err.Error(), properties)
+	l.print(LevelFatal, err.Error(), mapAttrs(properties))
 	os.Exit(1) // for enteries at the FATAL level, terminate the application.
 }
 
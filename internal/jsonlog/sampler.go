@@ -0,0 +1,59 @@
+package jsonlog
+
+import (
+	"sync"
+	"time"
+)
+
+// sampler counts the entries with the same level and message in the current tick
+type sampler struct {
+	first      int
+	thereafter int
+	tick       time.Duration
+
+	mu     sync.Mutex
+	counts map[samplerKey]*sampleCount
+}
+
+type samplerKey struct {
+	level   Level
+	message string
+}
+
+type sampleCount struct {
+	n       int
+	resetAt time.Time
+}
+
+func newSampler(first, thereafter int, tick time.Duration) *sampler {
+	if tick <= 0 {
+		tick = time.Second
+	}
+	return &sampler{
+		first:      first,
+		thereafter: thereafter,
+		tick:       tick,
+		counts:     make(map[samplerKey]*sampleCount),
+	}
+}
+
+func (s *sampler) allow(level Level, message string) bool {
+	now := time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := samplerKey{level: level, message: message}
+	count, ok := s.counts[key]
+	if !ok || now.After(count.resetAt) {
+		// the map only ever holds the distinct messages of the code, no need to evict anything
+		count = &sampleCount{resetAt: now.Add(s.tick)}
+		s.counts[key] = count
+	}
+
+	count.n++
+	if count.n <= s.first {
+		return true
+	}
+	return s.thereafter > 0 && (count.n-s.first)%s.thereafter == 0
+}
@@ -0,0 +1,87 @@
+package jsonlog
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSamplerAllow(t *testing.T) {
+	tests := []struct {
+		name       string
+		first      int
+		thereafter int
+		want       []bool
+	}{
+		{"first then one in three", 2, 3, []bool{true, true, false, false, true, false, false, true}},
+		{"first then every one", 1, 1, []bool{true, true, true, true}},
+		{"first only", 3, 0, []bool{true, true, true, false, false, false}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newSampler(tt.first, tt.thereafter, time.Hour)
+
+			for i, want := range tt.want {
+				if got := s.allow(LevelInfo, "noisy"); got != want {
+					t.Errorf("entry %d: allow() = %t, want %t", i+1, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestSamplerCountsLevelsAndMessagesApart(t *testing.T) {
+	s := newSampler(1, 0, time.Hour)
+
+	if !s.allow(LevelInfo, "a") || s.allow(LevelInfo, "a") {
+		t.Fatal("the first entry must be allowed and the second dropped")
+	}
+	if !s.allow(LevelInfo, "b") {
+		t.Error("another message was sampled with the first")
+	}
+	if !s.allow(LevelWarn, "a") {
+		t.Error("the same message at another level was sampled with the first")
+	}
+}
+
+func TestSamplerTick(t *testing.T) {
+	s := newSampler(1, 0, 10*time.Millisecond)
+
+	if !s.allow(LevelInfo, "noisy") || s.allow(LevelInfo, "noisy") {
+		t.Fatal("the first entry must be allowed and the second dropped")
+	}
+
+	time.Sleep(20 * time.Millisecond)
+
+	if !s.allow(LevelInfo, "noisy") {
+		t.Error("the count wasn't reset in the next tick")
+	}
+}
+
+func TestLoggerSampling(t *testing.T) {
+	var buf bytes.Buffer
+	logger := New(&buf, LevelDebug)
+	logger.SetSampling(1, 0, time.Hour)
+
+	for i := 0; i < 3; i++ {
+		logger.Info("noisy")
+		logger.Write([]byte("server error"))
+	}
+
+	if n := strings.Count(buf.String(), `"noisy"`); n != 1 {
+		t.Errorf("%d INFO entries written, want 1", n)
+	}
+	if n := strings.Count(buf.String(), `"server error"`); n != 3 {
+		t.Errorf("%d ERROR entries written, want all 3, errors are never sampled", n)
+	}
+
+	buf.Reset()
+	logger.SetSampling(0, 0, 0)
+	logger.Info("noisy")
+
+	if buf.Len() == 0 {
+		t.Error("entries still sampled after turning sampling off")
+	}
+}
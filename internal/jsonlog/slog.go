@@ -0,0 +1,104 @@
+package jsonlog
+
+import (
+	"context"
+	"log/slog"
+)
+
+// Handler returns an slog.Handler writing through l, for libraries logging with log/slog
+func (l *Logger) Handler() slog.Handler {
+	return &slogHandler{logger: l}
+}
+
+type slogHandler struct {
+	logger *Logger
+	group  string
+}
+
+func slogLevel(level slog.Level) Level {
+	switch {
+	case level < slog.LevelInfo:
+		return LevelDebug
+	case level < slog.LevelWarn:
+		return LevelInfo
+	case level < slog.LevelError:
+		return LevelWarn
+	default:
+		return LevelError
+	}
+}
+
+func (h *slogHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return h.logger.Enabled(slogLevel(level))
+}
+
+func (h *slogHandler) Handle(_ context.Context, record slog.Record) error {
+	attrs := make([]Attr, 0, record.NumAttrs())
+	record.Attrs(func(a slog.Attr) bool {
+		attrs = h.appendAttr(attrs, h.group, a)
+		return true
+	})
+
+	_, err := h.logger.print(slogLevel(record.Level), record.Message, attrs)
+	return err
+}
+
+func (h *slogHandler) WithAttrs(slogAttrs []slog.Attr) slog.Handler {
+	var attrs []Attr
+	for _, a := range slogAttrs {
+		attrs = h.appendAttr(attrs, h.group, a)
+	}
+	return &slogHandler{logger: h.logger.With(attrs...), group: h.group}
+}
+
+// WithGroup prefixes the keys of the attributes that follow, like "group.key"
+func (h *slogHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	return &slogHandler{logger: h.logger, group: h.prefix(h.group, name)}
+}
+
+func (h *slogHandler) prefix(group, key string) string {
+	if group == "" {
+		return key
+	}
+	return group + "." + key
+}
+
+func (h *slogHandler) appendAttr(attrs []Attr, group string, a slog.Attr) []Attr {
+	a.Value = a.Value.Resolve()
+	if a.Equal(slog.Attr{}) {
+		return attrs
+	}
+
+	if a.Value.Kind() == slog.KindGroup {
+		if a.Key != "" {
+			group = h.prefix(group, a.Key)
+		}
+		for _, member := range a.Value.Group() {
+			attrs = h.appendAttr(attrs, group, member)
+		}
+		return attrs
+	}
+
+	key := h.prefix(group, a.Key)
+	switch a.Value.Kind() {
+	case slog.KindString:
+		return append(attrs, String(key, a.Value.String()))
+	case slog.KindInt64:
+		return append(attrs, Int64(key, a.Value.Int64()))
+	case slog.KindUint64:
+		return append(attrs, Attr{Key: key, Value: a.Value.Uint64()})
+	case slog.KindFloat64:
+		return append(attrs, Float64(key, a.Value.Float64()))
+	case slog.KindBool:
+		return append(attrs, Bool(key, a.Value.Bool()))
+	case slog.KindDuration:
+		return append(attrs, Duration(key, a.Value.Duration()))
+	case slog.KindTime:
+		return append(attrs, Time(key, a.Value.Time()))
+	default:
+		return append(attrs, Any(key, a.Value.Any()))
+	}
+}
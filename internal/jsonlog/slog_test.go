@@ -0,0 +1,135 @@
+package jsonlog
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+)
+
+type entry struct {
+	Level      string                 `json:"level"`
+	Message    string                 `json:"message"`
+	Properties map[string]interface{} `json:"properties"`
+}
+
+func entries(t *testing.T, buf *bytes.Buffer) []entry {
+	t.Helper()
+
+	var list []entry
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+
+		var e entry
+		err := json.Unmarshal([]byte(line), &e)
+		if err != nil {
+			t.Fatalf("%q isn't JSON: %v", line, err)
+		}
+		list = append(list, e)
+	}
+	return list
+}
+
+func TestSlogLevels(t *testing.T) {
+	tests := []struct {
+		level slog.Level
+		want  string
+	}{
+		{slog.LevelDebug - 4, "DEBUG"},
+		{slog.LevelDebug, "DEBUG"},
+		{slog.LevelInfo, "INFO"},
+		{slog.LevelInfo + 2, "INFO"},
+		{slog.LevelWarn, "WARN"},
+		{slog.LevelError, "ERROR"},
+		{slog.LevelError + 4, "ERROR"},
+	}
+
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		logger := slog.New(New(&buf, LevelDebug).Handler())
+		logger.Log(context.Background(), tt.level, "message")
+
+		got := entries(t, &buf)
+		if len(got) != 1 || got[0].Level != tt.want {
+			t.Errorf("slog level %s written as %+v, want %s", tt.level, got, tt.want)
+		}
+	}
+}
+
+func TestSlogEnabled(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(New(&buf, LevelWarn).Handler())
+
+	logger.Debug("debug")
+	logger.Info("info")
+	logger.Warn("warn")
+
+	got := entries(t, &buf)
+	if len(got) != 1 || got[0].Message != "warn" {
+		t.Errorf("entries below WARN were written: %+v", got)
+	}
+}
+
+func TestSlogAttrs(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(New(&buf, LevelDebug).Handler())
+
+	logger.
+		With("component", "pgx").
+		WithGroup("query").
+		With("pid", 42).
+		Info("slow query",
+			"sql", "SELECT 1",
+			"duration", 1500*time.Millisecond,
+			"ok", true,
+			"ratio", 0.5,
+			slog.Group("conn", "host", "localhost", "port", uint64(5432)),
+			"err", errors.New("boom"),
+			slog.Attr{},
+		)
+
+	got := entries(t, &buf)
+	if len(got) != 1 {
+		t.Fatalf("got %d entries, want 1", len(got))
+	}
+
+	want := map[string]interface{}{
+		"component":       "pgx",
+		"query.pid":       float64(42),
+		"query.sql":       "SELECT 1",
+		"query.duration":  "1.5s",
+		"query.ok":        true,
+		"query.ratio":     0.5,
+		"query.conn.host": "localhost",
+		"query.conn.port": float64(5432),
+	}
+	for key, value := range want {
+		if got[0].Properties[key] != value {
+			t.Errorf("property %q = %#v, want %#v", key, got[0].Properties[key], value)
+		}
+	}
+	if _, ok := got[0].Properties["query.err"]; !ok {
+		t.Error("the error attribute is missing")
+	}
+	if _, ok := got[0].Properties[""]; ok {
+		t.Error("an empty attribute was written")
+	}
+}
+
+func TestSlogGroupWithoutKey(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(New(&buf, LevelDebug).Handler())
+
+	logger.Info("inline", slog.Group("", "a", 1), slog.Group("empty"))
+
+	got := entries(t, &buf)
+	if len(got) != 1 || got[0].Properties["a"] != float64(1) || len(got[0].Properties) != 1 {
+		t.Errorf("got %+v, want only the property a inlined", got)
+	}
+}
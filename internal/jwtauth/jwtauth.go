@@ -0,0 +1,222 @@
+package jwtauth
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	AlgorithmHS256 = "HS256"
+	AlgorithmEdDSA = "EdDSA"
+)
+
+var ErrInvalidToken = errors.New("invalid jwt")
+
+// Claims are the claims embedded in every JWT issued for a user, the subject holds the user id.
+// TokenVersion is compared to the user's current one, bumping it revokes the tokens issued before
+type Claims struct {
+	Activated    bool     `json:"activated"`
+	Permissions  []string `json:"permissions"`
+	TokenVersion int      `json:"token_version"`
+	jwt.RegisteredClaims
+}
+
+// Key is a signing key identified by the kid header of the tokens it signs. Secret is used
+// by HS256 and PrivateKey by EdDSA
+type Key struct {
+	ID         string
+	Secret     []byte
+	PrivateKey ed25519.PrivateKey
+}
+
+// Issuer signs and verifies JWTs. tokens are always signed with the active key, but any of the
+// configured keys is accepted for verification so keys can be rotated without logging everyone out
+type Issuer struct {
+	method    jwt.SigningMethod
+	keys      map[string]Key
+	activeKey Key
+	issuer    string
+	ttl       time.Duration
+}
+
+func New(algorithm string, keys []Key, activeKeyID, issuer string, ttl time.Duration) (*Issuer, error) {
+	var method jwt.SigningMethod
+	switch algorithm {
+	case AlgorithmHS256:
+		method = jwt.SigningMethodHS256
+	case AlgorithmEdDSA:
+		method = jwt.SigningMethodEdDSA
+	default:
+		return nil, fmt.Errorf("unsupported jwt algorithm %q", algorithm)
+	}
+
+	i := &Issuer{
+		method: method,
+		keys:   make(map[string]Key, len(keys)),
+		issuer: issuer,
+		ttl:    ttl,
+	}
+
+	for _, key := range keys {
+		i.keys[key.ID] = key
+	}
+
+	activeKey, ok := i.keys[activeKeyID]
+	if !ok {
+		return nil, fmt.Errorf("active jwt key %q is not one of the configured keys", activeKeyID)
+	}
+	i.activeKey = activeKey
+
+	return i, nil
+}
+
+// ParseKeys parses a comma separated list of kid:base64-key pairs. HS256 keys are the raw secret
+// (at least 32 bytes), EdDSA keys are either the 32 bytes seed or the 64 bytes ed25519 private key
+func ParseKeys(algorithm, spec string) ([]Key, error) {
+	var keys []Key
+
+	for _, pair := range strings.Split(spec, ",") {
+		id, encoded, found := strings.Cut(strings.TrimSpace(pair), ":")
+		if !found || id == "" {
+			return nil, fmt.Errorf("jwt key must be in the kid:base64-key format")
+		}
+
+		raw, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("jwt key %q is not valid base64: %w", id, err)
+		}
+
+		key := Key{ID: id}
+		switch {
+		case algorithm == AlgorithmHS256 && len(raw) >= 32:
+			key.Secret = raw
+		case algorithm == AlgorithmEdDSA && len(raw) == ed25519.SeedSize:
+			key.PrivateKey = ed25519.NewKeyFromSeed(raw)
+		case algorithm == AlgorithmEdDSA && len(raw) == ed25519.PrivateKeySize:
+			key.PrivateKey = ed25519.PrivateKey(raw)
+		default:
+			return nil, fmt.Errorf("jwt key %q has an invalid length for %s", id, algorithm)
+		}
+
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
+
+// Issue returns a signed JWT for the user along with its expiry
+func (i *Issuer) Issue(userID int64, activated bool, tokenVersion int, permissions []string) (string, time.Time, error) {
+	now := time.Now()
+	expiry := now.Add(i.ttl)
+
+	claims := Claims{
+		Activated:    activated,
+		Permissions:  permissions,
+		TokenVersion: tokenVersion,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    i.issuer,
+			Subject:   strconv.FormatInt(userID, 10),
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(expiry),
+		},
+	}
+
+	token := jwt.NewWithClaims(i.method, claims)
+	token.Header["kid"] = i.activeKey.ID
+
+	signed, err := token.SignedString(i.signingKey(i.activeKey))
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	return signed, expiry, nil
+}
+
+// Verify checks the signature and the registered claims of a JWT and returns the user id it was issued for
+func (i *Issuer) Verify(tokenString string) (int64, *Claims, error) {
+	var claims Claims
+
+	_, err := jwt.ParseWithClaims(tokenString, &claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		key, ok := i.keys[kid]
+		if !ok {
+			return nil, fmt.Errorf("unknown jwt key %q", kid)
+		}
+		return i.verificationKey(key), nil
+	},
+		jwt.WithValidMethods([]string{i.method.Alg()}),
+		jwt.WithIssuer(i.issuer),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil {
+		return 0, nil, ErrInvalidToken
+	}
+
+	userID, err := strconv.ParseInt(claims.Subject, 10, 64)
+	if err != nil || userID < 1 {
+		return 0, nil, ErrInvalidToken
+	}
+
+	return userID, &claims, nil
+}
+
+// JWK is a public key in the JSON Web Key format
+type JWK struct {
+	KeyType   string `json:"kty"`
+	Curve     string `json:"crv"`
+	X         string `json:"x"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+}
+
+// JWKS returns the public keys tokens can be verified with. HS256 keys are secrets, so none are published for them
+func (i *Issuer) JWKS() []JWK {
+	keys := []JWK{}
+	if i.method != jwt.SigningMethodEdDSA {
+		return keys
+	}
+
+	for _, key := range i.keys {
+		keys = append(keys, JWK{
+			KeyType:   "OKP",
+			Curve:     "Ed25519",
+			X:         base64.RawURLEncoding.EncodeToString(key.PrivateKey.Public().(ed25519.PublicKey)),
+			KeyID:     key.ID,
+			Use:       "sig",
+			Algorithm: AlgorithmEdDSA,
+		})
+	}
+
+	sort.Slice(keys, func(a, b int) bool { return keys[a].KeyID < keys[b].KeyID })
+
+	return keys
+}
+
+func (i *Issuer) signingKey(key Key) interface{} {
+	if i.method == jwt.SigningMethodEdDSA {
+		return key.PrivateKey
+	}
+	return key.Secret
+}
+
+func (i *Issuer) verificationKey(key Key) interface{} {
+	if i.method == jwt.SigningMethodEdDSA {
+		return key.PrivateKey.Public()
+	}
+	return key.Secret
+}
+
+// LooksLikeJWT reports whether a bearer token is a JWT rather than one of the opaque tokens
+func LooksLikeJWT(token string) bool {
+	return strings.Count(token, ".") == 2
+}
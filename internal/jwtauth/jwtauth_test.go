@@ -0,0 +1,244 @@
+package jwtauth
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func hsKey(id string, b byte) Key {
+	return Key{ID: id, Secret: bytes.Repeat([]byte{b}, 32)}
+}
+
+func edKey(t *testing.T, id string, b byte) Key {
+	t.Helper()
+
+	keys, err := ParseKeys(AlgorithmEdDSA, id+":"+base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{b}, 32)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keys[0]
+}
+
+func newIssuer(t *testing.T, algorithm string, keys []Key, active string, ttl time.Duration) *Issuer {
+	t.Helper()
+
+	i, err := New(algorithm, keys, active, "greenlight", ttl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return i
+}
+
+func TestIssueVerify(t *testing.T) {
+	tests := []struct {
+		algorithm string
+		key       Key
+	}{
+		{AlgorithmHS256, hsKey("hs", 1)},
+		{AlgorithmEdDSA, edKey(t, "ed", 1)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.algorithm, func(t *testing.T) {
+			i := newIssuer(t, tt.algorithm, []Key{tt.key}, tt.key.ID, time.Minute)
+
+			signed, expiry, err := i.Issue(42, true, 3, []string{"movies:read"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !LooksLikeJWT(signed) {
+				t.Errorf("%q doesn't look like a JWT", signed)
+			}
+			if until := time.Until(expiry); until <= 0 || until > time.Minute {
+				t.Errorf("expiry is %s away, want within a minute", until)
+			}
+
+			userID, claims, err := i.Verify(signed)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if userID != 42 {
+				t.Errorf("user id = %d, want 42", userID)
+			}
+			if !claims.Activated || claims.TokenVersion != 3 || !reflect.DeepEqual(claims.Permissions, []string{"movies:read"}) {
+				t.Errorf("unexpected claims %+v", claims)
+			}
+		})
+	}
+}
+
+func TestVerifyRejects(t *testing.T) {
+	key := hsKey("a", 1)
+	i := newIssuer(t, AlgorithmHS256, []Key{key}, "a", time.Minute)
+
+	valid, _, err := i.Issue(42, true, 1, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expired, _, err := newIssuer(t, AlgorithmHS256, []Key{key}, "a", -time.Minute).Issue(42, true, 1, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	otherIssuer, err := New(AlgorithmHS256, []Key{key}, "a", "someone-else", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wrongIssuer, _, err := otherIssuer.Issue(42, true, 1, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wrongSecret, _, err := newIssuer(t, AlgorithmHS256, []Key{hsKey("a", 2)}, "a", time.Minute).Issue(42, true, 1, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	unknownKey, _, err := newIssuer(t, AlgorithmHS256, []Key{hsKey("b", 1)}, "b", time.Minute).Issue(42, true, 1, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	otherAlgorithm, _, err := newIssuer(t, AlgorithmEdDSA, []Key{edKey(t, "a", 1)}, "a", time.Minute).Issue(42, true, 1, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parts := strings.Split(valid, ".")
+	unsigned := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none","typ":"JWT","kid":"a"}`)) + "." + parts[1] + "."
+	tampered := parts[0] + "." + base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"1","iss":"greenlight","exp":9999999999}`)) + "." + parts[2]
+
+	tests := map[string]string{
+		"expired":         expired,
+		"wrong issuer":    wrongIssuer,
+		"wrong secret":    wrongSecret,
+		"unknown key":     unknownKey,
+		"other algorithm": otherAlgorithm,
+		"alg none":        unsigned,
+		"tampered claims": tampered,
+		"garbage":         "a.b.c",
+	}
+
+	for name, token := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, _, err := i.Verify(token)
+			if !errors.Is(err, ErrInvalidToken) {
+				t.Errorf("Verify() error = %v, want ErrInvalidToken", err)
+			}
+		})
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	for _, algorithm := range []string{AlgorithmHS256, AlgorithmEdDSA} {
+		t.Run(algorithm, func(t *testing.T) {
+			oldKey, newKey := hsKey("old", 1), hsKey("new", 2)
+			if algorithm == AlgorithmEdDSA {
+				oldKey, newKey = edKey(t, "old", 1), edKey(t, "new", 2)
+			}
+
+			before := newIssuer(t, algorithm, []Key{oldKey}, "old", time.Minute)
+			oldToken, _, err := before.Issue(1, true, 1, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// the new key signs, the old one is still accepted while its tokens expire
+			during := newIssuer(t, algorithm, []Key{oldKey, newKey}, "new", time.Minute)
+			newToken, _, err := during.Issue(1, true, 1, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if _, _, err := during.Verify(oldToken); err != nil {
+				t.Errorf("token of the previous key rejected during the rotation: %v", err)
+			}
+			if _, _, err := during.Verify(newToken); err != nil {
+				t.Errorf("token of the active key rejected: %v", err)
+			}
+			if _, _, err := before.Verify(newToken); err == nil {
+				t.Error("token of the new key accepted by an issuer that doesn't know it")
+			}
+
+			after := newIssuer(t, algorithm, []Key{newKey}, "new", time.Minute)
+			if _, _, err := after.Verify(oldToken); err == nil {
+				t.Error("token of a removed key accepted")
+			}
+			if _, _, err := after.Verify(newToken); err != nil {
+				t.Errorf("token of the active key rejected after the rotation: %v", err)
+			}
+		})
+	}
+}
+
+func TestNew(t *testing.T) {
+	if _, err := New("RS256", []Key{hsKey("a", 1)}, "a", "greenlight", time.Minute); err == nil {
+		t.Error("unsupported algorithm accepted")
+	}
+	if _, err := New(AlgorithmHS256, []Key{hsKey("a", 1)}, "b", "greenlight", time.Minute); err == nil {
+		t.Error("active key that isn't configured accepted")
+	}
+}
+
+func TestParseKeys(t *testing.T) {
+	b64 := func(n int) string { return base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{7}, n)) }
+
+	tests := []struct {
+		name      string
+		algorithm string
+		spec      string
+		wantIDs   []string
+		wantErr   bool
+	}{
+		{"hs256", AlgorithmHS256, "a:" + b64(32), []string{"a"}, false},
+		{"hs256 several keys", AlgorithmHS256, "a:" + b64(32) + ", b:" + b64(48), []string{"a", "b"}, false},
+		{"hs256 short secret", AlgorithmHS256, "a:" + b64(16), nil, true},
+		{"eddsa seed", AlgorithmEdDSA, "a:" + b64(32), []string{"a"}, false},
+		{"eddsa private key", AlgorithmEdDSA, "a:" + b64(64), []string{"a"}, false},
+		{"eddsa wrong length", AlgorithmEdDSA, "a:" + b64(48), nil, true},
+		{"missing kid", AlgorithmHS256, ":" + b64(32), nil, true},
+		{"missing separator", AlgorithmHS256, b64(32), nil, true},
+		{"invalid base64", AlgorithmHS256, "a:!!!", nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keys, err := ParseKeys(tt.algorithm, tt.spec)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseKeys() error = %v, want error %t", err, tt.wantErr)
+			}
+
+			var ids []string
+			for _, key := range keys {
+				ids = append(ids, key.ID)
+			}
+			if !reflect.DeepEqual(ids, tt.wantIDs) {
+				t.Errorf("key ids = %q, want %q", ids, tt.wantIDs)
+			}
+		})
+	}
+}
+
+func TestJWKS(t *testing.T) {
+	hs := newIssuer(t, AlgorithmHS256, []Key{hsKey("a", 1)}, "a", time.Minute)
+	if keys := hs.JWKS(); len(keys) != 0 {
+		t.Errorf("HS256 secrets published: %+v", keys)
+	}
+
+	ed := newIssuer(t, AlgorithmEdDSA, []Key{edKey(t, "b", 2), edKey(t, "a", 1)}, "a", time.Minute)
+	keys := ed.JWKS()
+	if len(keys) != 2 || keys[0].KeyID != "a" || keys[1].KeyID != "b" {
+		t.Fatalf("JWKS() = %+v, want the keys a and b in order", keys)
+	}
+	for _, key := range keys {
+		if key.KeyType != "OKP" || key.Curve != "Ed25519" || key.Algorithm != AlgorithmEdDSA {
+			t.Errorf("unexpected JWK %+v", key)
+		}
+	}
+}
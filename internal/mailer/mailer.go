@@ -2,11 +2,16 @@ package mailer
 
 import (
 	"bytes"
+	"context"
 	"embed"
 	"text/template"
 	"time"
 
 	"github.com/go-mail/mail/v2"
+	"github.com/s-devoe/greenlight-go/internal/metrics"
+	"github.com/s-devoe/greenlight-go/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 //go:embed templates/*
@@ -23,7 +28,19 @@ func New(host string, port int, username, password, sender string) Mailer {
 	return Mailer{dailer: dialer, sender: sender}
 }
 
-func (m Mailer) SendMail(recipient, templateFile string, data interface{}) error {
+func (m Mailer) SendMail(ctx context.Context, recipient, templateFile string, data interface{}) (err error) {
+	_, span := tracing.Tracer().Start(ctx, "mailer.send", trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("mail.template", templateFile)))
+
+	defer func() {
+		outcome := "sent"
+		if err != nil {
+			outcome = "failed"
+			tracing.RecordError(span, err)
+		}
+		metrics.MailSends.WithLabelValues(templateFile, outcome).Inc()
+		span.End()
+	}()
 
 	tmpl, err := template.New("email").ParseFS(templateFS, "templates/"+templateFile)
 	if err != nil {
@@ -61,9 +78,29 @@ func (m Mailer) SendMail(recipient, templateFile string, data interface{}) error
 		if nil == err {
 			return nil
 		}
+		span.AddEvent("send failed", trace.WithAttributes(attribute.Int("attempt", i), attribute.String("error", err.Error())))
 
 		// if  it doesnt work,sleep for 1 second and retry
 		time.Sleep(1 * time.Second)
 	}
 	return err
 }
+
+// Ping connects and authenticates to the SMTP server without sending anything, giving up
+// by the ctx deadline.
+func (m Mailer) Ping(ctx context.Context) error {
+	dialer := *m.dailer
+	if deadline, ok := ctx.Deadline(); ok {
+		dialer.Timeout = time.Until(deadline)
+		if dialer.Timeout <= 0 {
+			return context.DeadlineExceeded
+		}
+	}
+
+	conn, err := dialer.Dial()
+	if err != nil {
+		return err
+	}
+
+	return conn.Close()
+}
@@ -0,0 +1,100 @@
+// Package metrics holds the Prometheus metrics of the API, served in the text exposition format by Handler
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "greenlight"
+
+// Registry is used instead of the default one, so only the metrics registered here are exposed
+var Registry = prometheus.NewRegistry()
+
+var factory = promauto.With(Registry)
+
+var (
+	// requests are labelled with the route pattern, like /v1/movies/:id, rather than the path,
+	// which would make a new series for every id
+	HTTPRequests = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests handled, by route pattern, method and status code.",
+	}, []string{"route", "method", "status"})
+
+	HTTPRequestDuration = factory.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Time taken to handle HTTP requests, by route pattern, method and status code.",
+		Buckets:   []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10},
+	}, []string{"route", "method", "status"})
+
+	HTTPRequestsInFlight = factory.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "http_requests_in_flight",
+		Help:      "HTTP requests being handled.",
+	})
+
+	// RateLimited counts the requests turned away by a limiter, "ip" for the global per-IP limiter
+	RateLimited = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rate_limited_requests_total",
+		Help:      "Requests rejected by a rate limiter, by limiter.",
+	}, []string{"limiter"})
+
+	// MailSends counts emails by template and outcome, "sent" or "failed" (after the retries)
+	MailSends = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "mailer_sends_total",
+		Help:      "Emails the mailer tried to send, by template and outcome.",
+	}, []string{"template", "outcome"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+}
+
+// RegisterPool exposes the statistics of a database connection pool
+func RegisterPool(pool *pgxpool.Pool) {
+	stat := func(name, help string, value func(s *pgxpool.Stat) float64) prometheus.Collector {
+		return prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "db_pool",
+			Name:      name,
+			Help:      help,
+		}, func() float64 { return value(pool.Stat()) })
+	}
+	counter := func(name, help string, value func(s *pgxpool.Stat) float64) prometheus.Collector {
+		return prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "db_pool",
+			Name:      name,
+			Help:      help,
+		}, func() float64 { return value(pool.Stat()) })
+	}
+
+	Registry.MustRegister(
+		stat("total_conns", "Connections in the pool.", func(s *pgxpool.Stat) float64 { return float64(s.TotalConns()) }),
+		stat("idle_conns", "Idle connections in the pool.", func(s *pgxpool.Stat) float64 { return float64(s.IdleConns()) }),
+		stat("acquired_conns", "Connections currently acquired from the pool.", func(s *pgxpool.Stat) float64 { return float64(s.AcquiredConns()) }),
+		stat("constructing_conns", "Connections being established.", func(s *pgxpool.Stat) float64 { return float64(s.ConstructingConns()) }),
+		stat("max_conns", "Maximum size of the pool.", func(s *pgxpool.Stat) float64 { return float64(s.MaxConns()) }),
+		counter("new_conns_total", "Connections opened.", func(s *pgxpool.Stat) float64 { return float64(s.NewConnsCount()) }),
+		counter("acquires_total", "Successful acquires from the pool.", func(s *pgxpool.Stat) float64 { return float64(s.AcquireCount()) }),
+		counter("empty_acquires_total", "Acquires that had to wait for a connection.", func(s *pgxpool.Stat) float64 { return float64(s.EmptyAcquireCount()) }),
+		counter("canceled_acquires_total", "Acquires canceled by their context.", func(s *pgxpool.Stat) float64 { return float64(s.CanceledAcquireCount()) }),
+		counter("acquire_duration_seconds_total", "Time spent acquiring connections.", func(s *pgxpool.Stat) float64 { return s.AcquireDuration().Seconds() }),
+	)
+}
+
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
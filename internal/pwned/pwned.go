@@ -0,0 +1,185 @@
+// Package pwned checks passwords against lists of known passwords, without calling out to any service:
+// a bundled list of the most common passwords, and optionally a local copy of the Have I Been Pwned
+// (HIBP) password hashes
+package pwned
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	_ "embed"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// the most common passwords of at least 6 characters, lowercased, from the zxcvbn frequency lists
+//
+//go:embed common-passwords.txt
+var commonPasswordsFile string
+
+var commonPasswords = func() map[string]struct{} {
+	passwords := make(map[string]struct{})
+	for _, password := range strings.Split(commonPasswordsFile, "\n") {
+		if password != "" {
+			passwords[password] = struct{}{}
+		}
+	}
+	return passwords
+}()
+
+// IsCommon reports whether the password, ignoring case, is in the bundled common-password list
+func IsCommon(password string) bool {
+	_, found := commonPasswords[strings.ToLower(password)]
+	return found
+}
+
+// Index looks up SHA-1 hashes in a local copy of the HIBP passwords, which is either a directory of
+// k-anonymity range files as served by the range API (a file per 5 character hash prefix, named like
+// 21BD1.txt, holding SUFFIX:COUNT lines) or a single SHA1:COUNT file ordered by hash
+type Index struct {
+	path  string
+	isDir bool
+}
+
+func Open(path string) (*Index, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Index{path: path, isDir: info.IsDir()}, nil
+}
+
+// Count returns how many times the password appears in breaches, 0 if it doesn't
+func (i *Index) Count(password string) (int, error) {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+
+	if i.isDir {
+		return i.countInRange(hash)
+	}
+	return i.countInOrderedFile(hash)
+}
+
+func (i *Index) countInRange(hash string) (int, error) {
+	f, err := os.Open(filepath.Join(i.path, hash[:5]+".txt"))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		suffix, count, ok := parseLine(scanner.Text())
+		if ok && strings.EqualFold(suffix, hash[5:]) {
+			return count, nil
+		}
+	}
+
+	return 0, scanner.Err()
+}
+
+// countInOrderedFile binary searches the file by byte offset, since the full list is far too large to load
+func (i *Index) countInOrderedFile(hash string) (int, error) {
+	f, err := os.Open(i.path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+
+	// the line with the hash, if there is one, starts in [lo, hi), lo always being the start of a line
+	lo, hi := int64(0), info.Size()
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+
+		start, line, err := lineAt(f, mid)
+		if err != nil {
+			return 0, err
+		}
+		if start >= hi {
+			hi = mid
+			continue
+		}
+
+		lineHash, count, ok := parseLine(line)
+		if !ok {
+			return 0, fmt.Errorf("pwned: malformed line at offset %d", start)
+		}
+
+		switch c := strings.Compare(strings.ToUpper(lineHash), hash); {
+		case c == 0:
+			return count, nil
+		case c < 0:
+			lo = start + int64(len(line)) + 1
+		default:
+			hi = start
+		}
+	}
+
+	return 0, nil
+}
+
+// lineAt returns the first line starting at or after offset, without its newline
+func lineAt(f *os.File, offset int64) (int64, string, error) {
+	// lines are a 40 character hash and a count, a partial line and a full one fit easily
+	const chunk = 256
+
+	start := offset
+	if offset > 0 {
+		// read from the byte before, so a line starting right at offset is found too
+		start = offset - 1
+	}
+
+	buf := make([]byte, chunk)
+	n, err := f.ReadAt(buf, start)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return 0, "", err
+	}
+	buf = buf[:n]
+
+	if offset > 0 {
+		nl := bytes.IndexByte(buf, '\n')
+		if nl < 0 {
+			return start + int64(n), "", nil
+		}
+		start += int64(nl) + 1
+		buf = buf[nl+1:]
+	}
+
+	nl := bytes.IndexByte(buf, '\n')
+	if nl < 0 {
+		if n == chunk {
+			return 0, "", fmt.Errorf("pwned: line at offset %d is too long", start)
+		}
+		nl = len(buf)
+	}
+
+	return start, string(buf[:nl]), nil
+}
+
+func parseLine(line string) (hash string, count int, ok bool) {
+	hash, rest, found := strings.Cut(strings.TrimSpace(line), ":")
+	if !found {
+		return "", 0, false
+	}
+
+	count, err := strconv.Atoi(rest)
+	if err != nil {
+		return "", 0, false
+	}
+	return hash, count, true
+}
@@ -0,0 +1,174 @@
+package pwned
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func sha1Hex(password string) string {
+	sum := sha1.Sum([]byte(password))
+	return strings.ToUpper(hex.EncodeToString(sum[:]))
+}
+
+// breached returns n passwords, the ith of which appears i+1 times in breaches
+func breached(n int) map[string]int {
+	passwords := make(map[string]int, n)
+	for i := 0; i < n; i++ {
+		passwords[fmt.Sprintf("breached-%d", i)] = i + 1
+	}
+	return passwords
+}
+
+func writeOrderedFile(t *testing.T, passwords map[string]int, newline string, trailing bool) string {
+	t.Helper()
+
+	var lines []string
+	for password, count := range passwords {
+		lines = append(lines, fmt.Sprintf("%s:%d", sha1Hex(password), count))
+	}
+	sort.Strings(lines)
+
+	content := strings.Join(lines, newline)
+	if trailing && len(lines) > 0 {
+		content += newline
+	}
+
+	path := filepath.Join(t.TempDir(), "pwned-passwords-sha1-ordered-by-hash.txt")
+	err := os.WriteFile(path, []byte(content), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func writeRangeDir(t *testing.T, passwords map[string]int) string {
+	t.Helper()
+
+	ranges := make(map[string][]string)
+	for password, count := range passwords {
+		hash := sha1Hex(password)
+		ranges[hash[:5]] = append(ranges[hash[:5]], fmt.Sprintf("%s:%d", hash[5:], count))
+	}
+
+	dir := t.TempDir()
+	for prefix, lines := range ranges {
+		err := os.WriteFile(filepath.Join(dir, prefix+".txt"), []byte(strings.Join(lines, "\r\n")), 0o600)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestCount(t *testing.T) {
+	passwords := breached(500)
+
+	sources := map[string]string{
+		"ordered file":                  writeOrderedFile(t, passwords, "\n", true),
+		"ordered file crlf":             writeOrderedFile(t, passwords, "\r\n", true),
+		"ordered file without trailing": writeOrderedFile(t, passwords, "\n", false),
+		"range directory":               writeRangeDir(t, passwords),
+	}
+
+	for name, path := range sources {
+		t.Run(name, func(t *testing.T) {
+			index, err := Open(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for password, want := range passwords {
+				got, err := index.Count(password)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got != want {
+					t.Errorf("Count(%q) = %d, want %d", password, got, want)
+				}
+			}
+
+			for i := 0; i < 100; i++ {
+				password := fmt.Sprintf("never-breached-%d", i)
+				got, err := index.Count(password)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got != 0 {
+					t.Errorf("Count(%q) = %d, want 0", password, got)
+				}
+			}
+		})
+	}
+}
+
+func TestCountEdges(t *testing.T) {
+	tests := []struct {
+		name      string
+		passwords map[string]int
+	}{
+		{"empty file", map[string]int{}},
+		{"single line", map[string]int{"only": 7}},
+		{"two lines", map[string]int{"first": 1, "second": 2}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			index, err := Open(writeOrderedFile(t, tt.passwords, "\n", true))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for password, want := range tt.passwords {
+				if got, err := index.Count(password); err != nil || got != want {
+					t.Errorf("Count(%q) = %d, %v, want %d", password, got, err, want)
+				}
+			}
+
+			if got, err := index.Count("missing"); err != nil || got != 0 {
+				t.Errorf("Count(missing) = %d, %v, want 0", got, err)
+			}
+		})
+	}
+}
+
+func TestCountMalformedFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "malformed.txt")
+	err := os.WriteFile(path, []byte("not a hash line\n"), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	index, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := index.Count("password"); err == nil {
+		t.Error("a malformed file wasn't reported")
+	}
+}
+
+func TestIsCommon(t *testing.T) {
+	tests := []struct {
+		password string
+		want     bool
+	}{
+		{"password", true},
+		{"PassWord", true},
+		{"qwerty", true},
+		{"correct horse battery staple", false},
+		{"", false},
+	}
+
+	for _, tt := range tests {
+		if got := IsCommon(tt.password); got != tt.want {
+			t.Errorf("IsCommon(%q) = %t, want %t", tt.password, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package sso
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+)
+
+var ErrEmailNotVerified = errors.New("email not verified")
+
+// ProviderConfig describes an OpenID Connect identity provider users can log in with
+type ProviderConfig struct {
+	Name         string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+}
+
+// Identity is what greenlight learns about a user from a verified ID token
+type Identity struct {
+	Subject string
+	Email   string
+	Name    string
+}
+
+// Provider runs the authorization code flow (with PKCE) against one identity provider. the
+// provider metadata is discovered on first use, so an unreachable provider doesn't stop the api from starting
+type Provider struct {
+	cfg ProviderConfig
+
+	mu       sync.Mutex
+	oauth    *oauth2.Config
+	verifier *oidc.IDTokenVerifier
+}
+
+func New(cfgs []ProviderConfig) map[string]*Provider {
+	providers := make(map[string]*Provider, len(cfgs))
+	for _, cfg := range cfgs {
+		providers[cfg.Name] = &Provider{cfg: cfg}
+	}
+	return providers
+}
+
+func (p *Provider) discover(ctx context.Context) (*oauth2.Config, *oidc.IDTokenVerifier, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.oauth != nil {
+		return p.oauth, p.verifier, nil
+	}
+
+	provider, err := oidc.NewProvider(ctx, p.cfg.Issuer)
+	if err != nil {
+		return nil, nil, fmt.Errorf("discovering oidc provider %q: %w", p.cfg.Name, err)
+	}
+
+	p.oauth = &oauth2.Config{
+		ClientID:     p.cfg.ClientID,
+		ClientSecret: p.cfg.ClientSecret,
+		RedirectURL:  p.cfg.RedirectURL,
+		Endpoint:     provider.Endpoint(),
+		Scopes:       []string{oidc.ScopeOpenID, "email", "profile"},
+	}
+	p.verifier = provider.Verifier(&oidc.Config{ClientID: p.cfg.ClientID})
+
+	return p.oauth, p.verifier, nil
+}
+
+// AuthCodeURL returns the url of the provider's login page the user must be sent to
+func (p *Provider) AuthCodeURL(ctx context.Context, state, nonce, codeVerifier string) (string, error) {
+	oauth, _, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	return oauth.AuthCodeURL(state, oidc.Nonce(nonce), oauth2.S256ChallengeOption(codeVerifier)), nil
+}
+
+// Exchange trades the authorization code for an ID token, verifies it and returns the identity it
+// carries. identities without a verified email address are rejected with ErrEmailNotVerified
+func (p *Provider) Exchange(ctx context.Context, code, nonce, codeVerifier string) (*Identity, error) {
+	oauth, verifier, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := oauth.Exchange(ctx, code, oauth2.VerifierOption(codeVerifier))
+	if err != nil {
+		return nil, err
+	}
+
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return nil, errors.New("token response is missing the id_token")
+	}
+
+	idToken, err := verifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		return nil, err
+	}
+
+	if idToken.Nonce != nonce {
+		return nil, errors.New("id token nonce mismatch")
+	}
+
+	var claims struct {
+		Email         string `json:"email"`
+		EmailVerified bool   `json:"email_verified"`
+		Name          string `json:"name"`
+	}
+	err = idToken.Claims(&claims)
+	if err != nil {
+		return nil, err
+	}
+
+	if claims.Email == "" || !claims.EmailVerified {
+		return nil, ErrEmailNotVerified
+	}
+
+	return &Identity{Subject: idToken.Subject, Email: claims.Email, Name: claims.Name}, nil
+}
@@ -0,0 +1,99 @@
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// codes follow RFC 6238 with the parameters every authenticator app supports
+const (
+	Digits = 6
+	Period = 30 * time.Second
+	// Skew is the number of periods before and after the current one a code is still accepted in
+	Skew = 1
+)
+
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// GenerateSecret returns a new random base32 encoded secret
+func GenerateSecret() (string, error) {
+	randomBytes := make([]byte, 20)
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return "", err
+	}
+	return encoding.EncodeToString(randomBytes), nil
+}
+
+// URI returns the otpauth:// uri authenticator apps enroll with (usually shown as a QR code)
+func URI(issuer, account, secret string) string {
+	values := url.Values{}
+	values.Set("secret", secret)
+	values.Set("issuer", issuer)
+	values.Set("algorithm", "SHA1")
+	values.Set("digits", fmt.Sprint(Digits))
+	values.Set("period", fmt.Sprint(int(Period.Seconds())))
+
+	label := url.PathEscape(issuer) + ":" + url.PathEscape(account)
+	return "otpauth://totp/" + label + "?" + values.Encode()
+}
+
+// Step returns the time step t falls in
+func Step(t time.Time) int64 {
+	return t.Unix() / int64(Period.Seconds())
+}
+
+// Code returns the code of the given time step
+func Code(secret string, step int64) (string, error) {
+	key, err := encoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", err
+	}
+
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], uint64(step))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(counter[:])
+	sum := mac.Sum(nil)
+
+	// dynamic truncation, see RFC 4226 section 5.3
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	modulo := uint32(1)
+	for i := 0; i < Digits; i++ {
+		modulo *= 10
+	}
+
+	return fmt.Sprintf("%0*d", Digits, value%modulo), nil
+}
+
+// Validate reports whether code is valid at t and returns the time step it matched, so the
+// caller can refuse a code that has already been used
+func Validate(secret, code string, t time.Time) (int64, bool) {
+	if len(code) != Digits {
+		return 0, false
+	}
+
+	current := Step(t)
+	for step := current - Skew; step <= current+Skew; step++ {
+		expected, err := Code(secret, step)
+		if err != nil {
+			return 0, false
+		}
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+
+	return 0, false
+}
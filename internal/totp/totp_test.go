@@ -0,0 +1,111 @@
+package totp
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA1 key of the RFC 6238 test vectors, "12345678901234567890" in base32
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestCode(t *testing.T) {
+	// the RFC 6238 appendix B vectors, cut down to our 6 digits
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+
+	for _, tt := range tests {
+		got, err := Code(rfcSecret, Step(time.Unix(tt.unix, 0)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("Code at %d = %s, want %s", tt.unix, got, tt.want)
+		}
+	}
+}
+
+func TestCodeLowercaseSecret(t *testing.T) {
+	upper, err := Code(rfcSecret, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lower, err := Code(strings.ToLower(rfcSecret), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if upper != lower {
+		t.Errorf("lowercase secret gave %s, want %s", lower, upper)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	current := Step(now)
+
+	code := func(step int64) string {
+		c, err := Code(rfcSecret, step)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+
+	tests := []struct {
+		name     string
+		code     string
+		wantStep int64
+		wantOK   bool
+	}{
+		{"current step", code(current), current, true},
+		{"previous step", code(current - Skew), current - Skew, true},
+		{"next step", code(current + Skew), current + Skew, true},
+		{"before the skew window", code(current - Skew - 1), 0, false},
+		{"after the skew window", code(current + Skew + 1), 0, false},
+		{"wrong code", "000000", 0, false},
+		{"too short", code(current)[:Digits-1], 0, false},
+		{"too long", code(current) + "0", 0, false},
+		{"empty", "", 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			step, ok := Validate(rfcSecret, tt.code, now)
+			if ok != tt.wantOK || step != tt.wantStep {
+				t.Errorf("Validate(%q) = (%d, %t), want (%d, %t)", tt.code, step, ok, tt.wantStep, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestValidateInvalidSecret(t *testing.T) {
+	if _, ok := Validate("not base32!", "123456", time.Now()); ok {
+		t.Error("a code was accepted for an invalid secret")
+	}
+}
+
+func TestGenerateSecret(t *testing.T) {
+	secret, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := encoding.DecodeString(secret)
+	if err != nil {
+		t.Fatalf("secret %q isn't base32: %v", secret, err)
+	}
+	if len(key) != 20 {
+		t.Errorf("secret is %d bytes, want 20", len(key))
+	}
+}
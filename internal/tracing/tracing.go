@@ -0,0 +1,120 @@
+// Package tracing sets up OpenTelemetry tracing: the exporter, W3C trace context propagation
+// and the spans for database queries
+package tracing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/jackc/pgx/v5"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "github.com/s-devoe/greenlight-go"
+
+type Config struct {
+	// Exporter is "none", "stdout" or "otlp". the OTLP exporter sends to Endpoint over HTTP,
+	// or to whatever the standard OTEL_EXPORTER_OTLP_* environment variables say when it's empty
+	Exporter    string
+	Endpoint    string
+	ServiceName string
+	Version     string
+	// SampleRatio is the share of new traces recorded, traces started upstream follow the caller's decision
+	SampleRatio float64
+}
+
+// Setup installs the global tracer provider and propagator. the returned function flushes the
+// spans not exported yet, it must be called before exiting
+func Setup(ctx context.Context, cfg Config) (func(context.Context) error, error) {
+	// W3C traceparent/tracestate and baggage, whatever the exporter, so traces started by callers
+	// continue through to the services called from here
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	var exporter sdktrace.SpanExporter
+	var err error
+
+	switch cfg.Exporter {
+	case "", "none":
+		return func(context.Context) error { return nil }, nil
+	case "stdout":
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	case "otlp":
+		var opts []otlptracehttp.Option
+		if cfg.Endpoint != "" {
+			opts = append(opts, otlptracehttp.WithEndpointURL(cfg.Endpoint))
+		}
+		exporter, err = otlptracehttp.New(ctx, opts...)
+	default:
+		return nil, fmt.Errorf("tracing: unknown exporter %q", cfg.Exporter)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(semconv.SchemaURL,
+		semconv.ServiceName(cfg.ServiceName),
+		semconv.ServiceVersion(cfg.Version),
+	))
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.SampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+
+	return provider.Shutdown, nil
+}
+
+// Tracer returns the tracer for the spans created by the API itself
+func Tracer() trace.Tracer {
+	return otel.Tracer(instrumentationName)
+}
+
+// RecordError marks the span as failed
+func RecordError(span trace.Span, err error) {
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
+
+// QueryTracer is a pgx.QueryTracer creating a client span for every query
+type QueryTracer struct{}
+
+func (QueryTracer) TraceQueryStart(ctx context.Context, conn *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	ctx, _ = Tracer().Start(ctx, "db.query",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.DBSystemPostgreSQL,
+			semconv.DBQueryText(data.SQL),
+			attribute.String("db.namespace", conn.Config().Database),
+		),
+	)
+	return ctx
+}
+
+func (QueryTracer) TraceQueryEnd(ctx context.Context, conn *pgx.Conn, data pgx.TraceQueryEndData) {
+	span := trace.SpanFromContext(ctx)
+	defer span.End()
+
+	// no rows is how a lookup reports a miss, not a failure
+	if data.Err != nil && !errors.Is(data.Err, pgx.ErrNoRows) {
+		RecordError(span, data.Err)
+		return
+	}
+
+	span.SetAttributes(attribute.Int64("db.rows_affected", data.CommandTag.RowsAffected()))
+}
@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS movie_credits (
+    id bigserial PRIMARY KEY,
+    movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+    name text NOT NULL,
+    role text NOT NULL CHECK (role IN ('cast', 'crew')),
+    job text NOT NULL DEFAULT '',
+    position integer NOT NULL DEFAULT 0
+);
+
+CREATE INDEX IF NOT EXISTS movie_credits_movie_id_idx ON movie_credits (movie_id, position);
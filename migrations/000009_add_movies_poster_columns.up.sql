@@ -0,0 +1,2 @@
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS poster_format text NOT NULL DEFAULT '';
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS poster_id text NOT NULL DEFAULT '';
@@ -0,0 +1,7 @@
+DROP INDEX IF EXISTS movies_title_year_key;
+
+ALTER TABLE movies DROP CONSTRAINT IF EXISTS movies_tmdb_id_key;
+ALTER TABLE movies DROP CONSTRAINT IF EXISTS movies_imdb_id_key;
+
+ALTER TABLE movies DROP COLUMN IF EXISTS tmdb_id;
+ALTER TABLE movies DROP COLUMN IF EXISTS imdb_id;
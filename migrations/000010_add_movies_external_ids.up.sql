@@ -0,0 +1,7 @@
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS imdb_id text;
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS tmdb_id bigint;
+
+ALTER TABLE movies ADD CONSTRAINT movies_imdb_id_key UNIQUE (imdb_id);
+ALTER TABLE movies ADD CONSTRAINT movies_tmdb_id_key UNIQUE (tmdb_id);
+
+CREATE UNIQUE INDEX IF NOT EXISTS movies_title_year_key ON movies (lower(title), year);
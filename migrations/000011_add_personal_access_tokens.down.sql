@@ -0,0 +1,3 @@
+ALTER TABLE tokens DROP COLUMN IF EXISTS permissions;
+ALTER TABLE tokens DROP COLUMN IF EXISTS name;
+ALTER TABLE tokens DROP COLUMN IF EXISTS id;
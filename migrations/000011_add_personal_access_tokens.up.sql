@@ -0,0 +1,4 @@
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS id bigserial UNIQUE;
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS name text NOT NULL DEFAULT '';
+-- the permissions a token is restricted to, NULL means the token carries all of its user's permissions
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS permissions text[];
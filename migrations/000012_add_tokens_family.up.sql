@@ -0,0 +1,4 @@
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS family text;
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS used bool NOT NULL DEFAULT false;
+
+CREATE INDEX IF NOT EXISTS tokens_family_idx ON tokens (family);
@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS user_identities (
+    provider text NOT NULL,
+    subject text NOT NULL,
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (provider, subject)
+);
+
+CREATE TABLE IF NOT EXISTS oidc_login_states (
+    hash bytea PRIMARY KEY,
+    provider text NOT NULL,
+    nonce text NOT NULL,
+    code_verifier text NOT NULL,
+    expiry timestamp(0) with time zone NOT NULL,
+    user_id bigint REFERENCES users ON DELETE CASCADE
+);
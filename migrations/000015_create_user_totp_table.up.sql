@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS user_totp (
+    user_id bigint PRIMARY KEY REFERENCES users ON DELETE CASCADE,
+    secret text NOT NULL,
+    enabled bool NOT NULL DEFAULT false,
+    last_used_step bigint NOT NULL DEFAULT 0,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
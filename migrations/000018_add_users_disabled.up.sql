@@ -0,0 +1 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS disabled bool NOT NULL DEFAULT false;
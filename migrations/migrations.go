@@ -0,0 +1,33 @@
+// Package migrations embeds the SQL migrations, so the API knows which schema version it expects.
+package migrations
+
+import (
+	"embed"
+	"io/fs"
+	"strconv"
+	"strings"
+)
+
+//go:embed *.sql
+var FS embed.FS
+
+// Latest returns the version of the newest migration, the one golang-migrate records in
+// schema_migrations once every migration has been applied.
+func Latest() (int64, error) {
+	files, err := fs.Glob(FS, "*.up.sql")
+	if err != nil {
+		return 0, err
+	}
+
+	var latest int64
+	for _, file := range files {
+		prefix, _, _ := strings.Cut(file, "_")
+		version, err := strconv.ParseInt(prefix, 10, 64)
+		if err != nil {
+			return 0, err
+		}
+		latest = max(latest, version)
+	}
+
+	return latest, nil
+}